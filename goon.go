@@ -36,9 +36,11 @@ func repl() {
     }
 
     if len(raw_line) > 1 {
-      val := runtime.Interperet(string(raw_line))
+      val, err := runtime.Interperet("<stdin>", string(raw_line))
 
-      if val != nil {
+      if err != nil {
+        report(err)
+      } else {
         fmt.Printf("%s\n", val)
       }
     }
@@ -53,5 +55,24 @@ func file(filename string) {
   }
 
   runtime := goon.New()
-  runtime.Interperet(string(input))
+  _, err = runtime.Interperet(filename, string(input))
+  if err != nil {
+    report(err)
+    os.Exit(1)
+  }
+}
+
+func report(err error) {
+  if errs, ok := err.(goon.ParseErrors); ok {
+    for _, err := range errs {
+      fmt.Printf("Error! %s\n", err)
+    }
+
+    return
+  }
+
+  fmt.Printf("Error! %s\n", err)
+  if e, ok := err.(*goon.RuntimeError); ok {
+    fmt.Print(e.Trace())
+  }
 }
\ No newline at end of file
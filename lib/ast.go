@@ -5,19 +5,63 @@ import (
   "strings"
 )
 
+// Evaluate never returns a nil value without an error.
 type ASTNode interface {
-  Evaluate(runtime *Runtime) *Value
+  Evaluate(runtime *Runtime) (*Value, error)
   Describe(indent int)
+  Span() Span
+}
+
+// node is embedded in every kind of node, to keep the span it was parsed from.
+type node struct {
+  span Span
+}
+
+func (n node) Span() Span {
+  return n.span
+}
+
+// fail makes an error that happened at the node.
+func (n node) fail(kind ErrorKind, format string, args ...interface{}) error {
+  e := NewError(kind, format, args...)
+  e.Span, e.at = n.span, n.span
+  return e
+}
+
+// locate places an error at the node, unless it already has a place. Either
+// way, the node is where it's got to in the current block.
+func (n node) locate(err error) error {
+  if e, ok := err.(*RuntimeError); ok {
+    if !e.Span.Known() {
+      e.Span = n.span
+    }
+
+    e.at = n.span
+  }
+
+  return err
+}
+
+// Assignable nodes can appear on the left side of an assignment.
+type Assignable interface {
+  ASTNode
+  Assign(runtime *Runtime, value *Value) error
+
+  // Update replaces the current value with fn's result, evaluating the
+  // target only once, and returns both.
+  Update(runtime *Runtime, fn func(*Value) (*Value, error)) (*Value, *Value, error)
 }
 
 // VALUE
 
 type ValueNode struct {
+  node
+
   value *Value
 }
 
-func (n *ValueNode) Evaluate(runtime *Runtime) *Value {
-  return n.value
+func (n *ValueNode) Evaluate(runtime *Runtime) (*Value, error) {
+  return n.value, nil
 }
 
 func (n *ValueNode) Describe(indent int) {
@@ -27,21 +71,370 @@ func (n *ValueNode) Describe(indent int) {
 // IDENT
 
 type IdentNode struct {
+  node
+
   ident string
 }
 
-func (n *IdentNode) Evaluate(runtime *Runtime) *Value {
-  return runtime.ns[n.ident];
+func (n *IdentNode) Evaluate(runtime *Runtime) (*Value, error) {
+  v, present := runtime.scope.Get(n.ident)
+  if !present {
+    return nil, n.fail(NameError, "Unknown name `%s`", n.ident)
+  }
+
+  return v, nil
+}
+
+func (n *IdentNode) Assign(runtime *Runtime, value *Value) error {
+  runtime.scope.Set(n.ident, value)
+  return nil
+}
+
+func (n *IdentNode) Update(runtime *Runtime, fn func(*Value) (*Value, error)) (*Value, *Value, error) {
+  old, err := n.Evaluate(runtime)
+  if err != nil {
+    return nil, nil, err
+  }
+
+  value, err := fn(old)
+  if err != nil {
+    return nil, nil, err
+  }
+
+  runtime.scope.Set(n.ident, value)
+  return old, value, nil
 }
 
 func (n *IdentNode) Describe(indent int) {
   fmt.Printf("# %sIDENT: `%s`\n", strings.Repeat("  ", indent), n.ident)
 }
 
+// MEMBER
+
+type MemberNode struct {
+  node
+
+  target ASTNode
+  ident string
+}
+
+func (n *MemberNode) Evaluate(runtime *Runtime) (*Value, error) {
+  v, err := n.target.Evaluate(runtime)
+  if err != nil {
+    return nil, err
+  }
+
+  return n.get(v)
+}
+
+func (n *MemberNode) get(v *Value) (*Value, error) {
+  switch v.val_type {
+  case BlockType:
+    locals, _ := v.val.(*Block).state()
+    if locals != nil {
+      if member, present := locals.Local(n.ident); present {
+        return member, nil
+      }
+    }
+  case ListType:
+    if method := v.val.(*List).Method(n.ident); method != nil {
+      return method, nil
+    }
+  case MapType:
+    if method := v.val.(*Map).Method(n.ident); method != nil {
+      return method, nil
+    }
+  case ErrorType:
+    e := v.val.(*RuntimeError)
+    switch n.ident {
+    case "kind":
+      return &Value{string(e.Kind), StringType}, nil
+    case "message":
+      return &Value{e.Message, StringType}, nil
+    }
+  }
+
+  return nil, n.fail(NameError, "No member `%s` on %s", n.ident, v.val_type)
+}
+
+func (n *MemberNode) Assign(runtime *Runtime, value *Value) error {
+  v, err := n.target.Evaluate(runtime)
+  if err != nil {
+    return err
+  }
+
+  return n.set(v, value)
+}
+
+func (n *MemberNode) Update(runtime *Runtime, fn func(*Value) (*Value, error)) (*Value, *Value, error) {
+  v, err := n.target.Evaluate(runtime)
+  if err != nil {
+    return nil, nil, err
+  }
+
+  old, err := n.get(v)
+  if err != nil {
+    return nil, nil, err
+  }
+
+  value, err := fn(old)
+  if err != nil {
+    return nil, nil, err
+  }
+
+  return old, value, n.set(v, value)
+}
+
+// set only works on blocks; there's nothing to assign to on anything else.
+func (n *MemberNode) set(v *Value, value *Value) error {
+  if v.val_type != BlockType {
+    return n.fail(TypeError, "Can't set `%s` on %s", n.ident, v.val_type)
+  }
+
+  v.val.(*Block).members().Define(n.ident, value)
+  return nil
+}
+
+func (n *MemberNode) Describe(indent int) {
+  fmt.Printf("# %sMEMBER `%s` OF:\n", strings.Repeat("  ", indent), n.ident)
+  n.target.Describe(indent+1)
+}
+
+// INDEX
+
+type IndexNode struct {
+  node
+
+  target ASTNode
+  index ASTNode
+}
+
+func (n *IndexNode) Evaluate(runtime *Runtime) (*Value, error) {
+  v, index, err := n.operands(runtime)
+  if err != nil {
+    return nil, err
+  }
+
+  return n.get(v, index)
+}
+
+// operands evaluates the target and the index.
+func (n *IndexNode) operands(runtime *Runtime) (*Value, *Value, error) {
+  v, err := n.target.Evaluate(runtime)
+  if err != nil {
+    return nil, nil, err
+  }
+
+  index, err := n.index.Evaluate(runtime)
+  if err != nil {
+    return nil, nil, err
+  }
+
+  return v, index, nil
+}
+
+func (n *IndexNode) get(v *Value, index *Value) (*Value, error) {
+  switch v.val_type {
+  case MapType:
+    if _, ok := keyOf(index); !ok {
+      return nil, n.fail(TypeError, "Can't use %s as a map key", index.val_type)
+    }
+
+    if item, present := v.val.(*Map).Get(index); present {
+      return item, nil
+    }
+  case ListType:
+    if index.val_type != IntType {
+      return nil, n.fail(TypeError, "Can't index a list with %s", index.val_type)
+    }
+
+    // a big int is out of range of anything
+    i, ok := index.Int()
+    if !ok {
+      return NIL, nil
+    }
+
+    list := v.val.(*List)
+    if i, ok := list.Index(i); ok {
+      return list.Get(i), nil
+    }
+  case StringType:
+    if index.val_type != IntType {
+      return nil, n.fail(TypeError, "Can't index a string with %s", index.val_type)
+    }
+
+    // a big int is out of range of anything
+    i, ok := index.Int()
+    if !ok {
+      return NIL, nil
+    }
+
+    runes := []rune(v.val.(string))
+    if i < 0 {
+      i += len(runes)
+    }
+
+    if i >= 0 && i < len(runes) {
+      return &Value{string(runes[i]), StringType}, nil
+    }
+  default:
+    return nil, n.fail(TypeError, "Can't index %s", v.val_type)
+  }
+
+  return NIL, nil
+}
+
+func (n *IndexNode) Assign(runtime *Runtime, value *Value) error {
+  v, index, err := n.operands(runtime)
+  if err != nil {
+    return err
+  }
+
+  return n.set(v, index, value)
+}
+
+func (n *IndexNode) Update(runtime *Runtime, fn func(*Value) (*Value, error)) (*Value, *Value, error) {
+  v, index, err := n.operands(runtime)
+  if err != nil {
+    return nil, nil, err
+  }
+
+  old, err := n.get(v, index)
+  if err != nil {
+    return nil, nil, err
+  }
+
+  value, err := fn(old)
+  if err != nil {
+    return nil, nil, err
+  }
+
+  return old, value, n.set(v, index, value)
+}
+
+// set stores into maps and lists. Reading a list item that's out of range
+// gives nil, but setting one raises, since the value would be lost.
+func (n *IndexNode) set(v *Value, index *Value, value *Value) error {
+  switch v.val_type {
+  case MapType:
+    if !v.val.(*Map).Set(index, value) {
+      return n.fail(TypeError, "Can't use %s as a map key", index.val_type)
+    }
+  case ListType:
+    if index.val_type != IntType {
+      return n.fail(TypeError, "Can't index a list with %s", index.val_type)
+    }
+
+    // a big int is out of range of anything
+    list := v.val.(*List)
+    i, ok := index.Int()
+    if ok {
+      i, ok = list.Index(i)
+    }
+
+    if !ok {
+      return n.fail(IndexError, "Index %s is out of range for a list of %d", index, list.Len())
+    }
+
+    list.Set(i, value)
+  default:
+    return n.fail(TypeError, "Can't set items on %s", v.val_type)
+  }
+
+  return nil
+}
+
+func (n *IndexNode) Describe(indent int) {
+  fmt.Printf("# %sINDEX:\n", strings.Repeat("  ", indent))
+  n.target.Describe(indent+1)
+  fmt.Printf("# %sAT:\n", strings.Repeat("  ", indent))
+  n.index.Describe(indent+1)
+}
+
+// LIST
+
+type ListNode struct {
+  node
+
+  items []ASTNode
+}
+
+func (n *ListNode) AddItem(item ASTNode) {
+  n.items = append(n.items, item)
+}
+
+func (n *ListNode) Evaluate(runtime *Runtime) (*Value, error) {
+  list := &List{items: make([]*Value, len(n.items))}
+  for i, item := range n.items {
+    v, err := item.Evaluate(runtime)
+    if err != nil {
+      return nil, err
+    }
+
+    list.items[i] = v
+  }
+
+  return &Value{list, ListType}, nil
+}
+
+func (n *ListNode) Describe(indent int) {
+  fmt.Printf("# %sLIST (%d):\n", strings.Repeat("  ", indent), len(n.items))
+  for _, item := range n.items {
+    item.Describe(indent+1)
+  }
+}
+
+// MAP
+
+type MapNode struct {
+  node
+
+  keys []ASTNode
+  values []ASTNode
+}
+
+func (n *MapNode) AddPair(key ASTNode, value ASTNode) {
+  n.keys = append(n.keys, key)
+  n.values = append(n.values, value)
+}
+
+func (n *MapNode) Evaluate(runtime *Runtime) (*Value, error) {
+  m := NewMap()
+  for i, key := range n.keys {
+    k, err := key.Evaluate(runtime)
+    if err != nil {
+      return nil, err
+    }
+
+    v, err := n.values[i].Evaluate(runtime)
+    if err != nil {
+      return nil, err
+    }
+
+    if !m.Set(k, v) {
+      return nil, node{key.Span()}.fail(TypeError, "Can't use %s as a map key", k.val_type)
+    }
+  }
+
+  return &Value{m, MapType}, nil
+}
+
+func (n *MapNode) Describe(indent int) {
+  fmt.Printf("# %sMAP (%d):\n", strings.Repeat("  ", indent), len(n.keys))
+  for i, key := range n.keys {
+    fmt.Printf("# %sKEY:\n", strings.Repeat("  ", indent+1))
+    key.Describe(indent+2)
+    fmt.Printf("# %sVALUE:\n", strings.Repeat("  ", indent+1))
+    n.values[i].Describe(indent+2)
+  }
+}
+
 // CALL
 
 type CallNode struct {
-  ident string
+  node
+
+  callee ASTNode
   arguments []ASTNode
 }
 
@@ -49,18 +442,149 @@ func (n *CallNode) AddArgument(arg ASTNode) {
   n.arguments = append(n.arguments, arg)
 }
 
-func (n *CallNode) Evaluate(runtime *Runtime) *Value {
-  // TODO
-  return NIL
+func (n *CallNode) Evaluate(runtime *Runtime) (*Value, error) {
+  callee, args, err := n.prepare(runtime)
+  if err != nil {
+    return nil, err
+  }
+
+  v, err := callee.Call(runtime, args)
+  return v, n.locate(err)
+}
+
+// prepare evaluates the callee and arguments, and makes sure the callee can be
+// called.
+func (n *CallNode) prepare(runtime *Runtime) (*Value, []*Value, error) {
+  callee, err := n.callee.Evaluate(runtime)
+  if err != nil {
+    return nil, nil, err
+  }
+
+  if !callee.Callable() {
+    return nil, nil, n.fail(TypeError, "Can't call %s", callee.val_type)
+  }
+
+  args := make([]*Value, len(n.arguments))
+  for i, arg := range n.arguments {
+    args[i], err = arg.Evaluate(runtime)
+    if err != nil {
+      return nil, nil, err
+    }
+  }
+
+  return callee, args, nil
 }
 
 func (n *CallNode) Describe(indent int) {
-  fmt.Printf("# %sCALL `%s` WITH ARGS:\n", strings.Repeat("  ", indent), n.ident)
+  if ident, ok := n.callee.(*IdentNode); ok {
+    fmt.Printf("# %sCALL `%s` WITH ARGS:\n", strings.Repeat("  ", indent), ident.ident)
+  } else {
+    fmt.Printf("# %sCALL:\n", strings.Repeat("  ", indent))
+    n.callee.Describe(indent+1)
+    fmt.Printf("# %sWITH ARGS:\n", strings.Repeat("  ", indent))
+  }
+
   for _, arg := range n.arguments {
     arg.Describe(indent+1)
   }
 }
 
+// SPAWN
+
+type SpawnNode struct {
+  node
+
+  call *CallNode
+}
+
+func (n *SpawnNode) Evaluate(runtime *Runtime) (*Value, error) {
+  // the callee and arguments are evaluated right away; only the call itself
+  // happens in the background
+  callee, args, err := n.call.prepare(runtime)
+  if err != nil {
+    return nil, err
+  }
+
+  return &Value{Spawn(runtime, callee, args), PromiseType}, nil
+}
+
+func (n *SpawnNode) Describe(indent int) {
+  fmt.Printf("# %sSPAWN:\n", strings.Repeat("  ", indent))
+  n.call.Describe(indent+1)
+}
+
+// AWAIT
+
+type AwaitNode struct {
+  node
+
+  expr ASTNode
+}
+
+func (n *AwaitNode) Evaluate(runtime *Runtime) (*Value, error) {
+  v, err := n.expr.Evaluate(runtime)
+  if err != nil {
+    return nil, err
+  }
+
+  v, err = Await(v)
+  return v, n.locate(err)
+}
+
+func (n *AwaitNode) Describe(indent int) {
+  fmt.Printf("# %sAWAIT:\n", strings.Repeat("  ", indent))
+  n.expr.Describe(indent+1)
+}
+
+// NEW
+
+type NewNode struct {
+  node
+
+  // either a block, to fork as it stands, or a CallNode, to call the block
+  // first and then fork the result
+  target ASTNode
+}
+
+func (n *NewNode) Evaluate(runtime *Runtime) (*Value, error) {
+  call, ok := n.target.(*CallNode)
+  if !ok {
+    v, err := n.target.Evaluate(runtime)
+    if err != nil {
+      return nil, err
+    }
+
+    if v.val_type != BlockType {
+      return nil, n.fail(TypeError, "Can't make a new %s", v.val_type)
+    }
+
+    return &Value{v.val.(*Block).Fork(), BlockType}, nil
+  }
+
+  callee, args, err := call.prepare(runtime)
+  if err != nil {
+    return nil, err
+  }
+
+  if callee.val_type != BlockType {
+    return nil, n.fail(TypeError, "Can't make a new %s", callee.val_type)
+  }
+
+  // the block may have been running already, and called as a fresh instance,
+  // so it's whichever instance ran that gets forked
+  _, instance, err := callee.val.(*Block).call(runtime, args)
+  if err != nil {
+    return nil, call.locate(err)
+  }
+
+  return &Value{instance.Fork(), BlockType}, nil
+}
+
+func (n *NewNode) Describe(indent int) {
+  fmt.Printf("# %sNEW:\n", strings.Repeat("  ", indent))
+  n.target.Describe(indent+1)
+}
+
 // EXPRESSION
 
 type Operator string
@@ -69,32 +593,81 @@ const (
   OrOp Operator         = "or"
   CompareOp Operator    = "=="
   InvCompareOp Operator = "!="
+  InOp Operator         = "in"
+  LessOp Operator         = "<"
+  LessEqualOp Operator    = "<="
+  GreaterOp Operator      = ">"
+  GreaterEqualOp Operator = ">="
   AddOp Operator        = "+"
   SubtractOp Operator   = "-"
   MultiplyOp Operator   = "*"
   DivideOp Operator     = "/"
+  IntDivideOp Operator  = "//"
+  ModuloOp Operator     = "%"
   PowerOp Operator      = "^"
+
+  // unary
+  NotOp Operator    = "not"
+  NegateOp Operator = "neg"
 )
 
 type ExpressionNode struct {
+  node
+
   operator Operator
   left ASTNode
   right ASTNode
 }
 
-func (n *ExpressionNode) Evaluate(runtime *Runtime) *Value {
-  left := n.left.Evaluate(runtime)
-  right := n.right.Evaluate(runtime)
+func (n *ExpressionNode) Evaluate(runtime *Runtime) (*Value, error) {
+  left, err := n.left.Evaluate(runtime)
+  if err != nil {
+    return nil, err
+  }
 
+  // and and or only look at the right side if the left doesn't settle it
   switch n.operator {
   case AndOp:
-    return left.And(right)
+    if !left.IsTruthy() {
+      return left, nil
+    }
+
+    return n.right.Evaluate(runtime)
   case OrOp:
-    return left.Or(right)
+    if left.IsTruthy() {
+      return left, nil
+    }
+
+    return n.right.Evaluate(runtime)
+  }
+
+  right, err := n.right.Evaluate(runtime)
+  if err != nil {
+    return nil, err
+  }
+
+  v, err := apply(n.operator, left, right)
+  return v, n.locate(err)
+}
+
+// apply works out an operator on two values. and and or never get here, since
+// Evaluate settles them before the right side is run.
+func apply(operator Operator, left *Value, right *Value) (*Value, error) {
+  switch operator {
   case CompareOp:
-    return left.Equals(right)
+    return left.Equals(right), nil
   case InvCompareOp:
-    return left.NotEquals(right)
+    return left.NotEquals(right), nil
+  case InOp:
+    return right.Contains(left), nil
+  case LessOp:
+    return left.Less(right)
+  case LessEqualOp:
+    return left.LessOrEqual(right)
+  case GreaterOp:
+    return left.Greater(right)
+  case GreaterEqualOp:
+    return left.GreaterOrEqual(right)
   case AddOp:
     return left.Add(right)
   case SubtractOp:
@@ -103,9 +676,15 @@ func (n *ExpressionNode) Evaluate(runtime *Runtime) *Value {
     return left.Multiply(right)
   case DivideOp:
     return left.Divide(right)
+  case IntDivideOp:
+    return left.IntDivide(right)
+  case ModuloOp:
+    return left.Modulo(right)
+  case PowerOp:
+    return left.Power(right)
   }
 
-  return NIL
+  return NIL, nil
 }
 
 func (n *ExpressionNode) Describe(indent int) {
@@ -119,6 +698,16 @@ func (n *ExpressionNode) Describe(indent int) {
     ex = "EQUALS"
   case InvCompareOp:
     ex = "NOT_EQUALS"
+  case InOp:
+    ex = "IN"
+  case LessOp:
+    ex = "LESS"
+  case LessEqualOp:
+    ex = "LESS_OR_EQUAL"
+  case GreaterOp:
+    ex = "GREATER"
+  case GreaterEqualOp:
+    ex = "GREATER_OR_EQUAL"
   case AddOp:
     ex = "ADD"
   case SubtractOp:
@@ -127,6 +716,12 @@ func (n *ExpressionNode) Describe(indent int) {
     ex = "MULTIPLY"
   case DivideOp:
     ex = "DIVIDE"
+  case IntDivideOp:
+    ex = "INT_DIVIDE"
+  case ModuloOp:
+    ex = "MODULO"
+  case PowerOp:
+    ex = "POWER"
   }
 
   fmt.Printf("# %s%s:\n", strings.Repeat("  ", indent), ex)
@@ -134,28 +729,174 @@ func (n *ExpressionNode) Describe(indent int) {
   n.right.Describe(indent + 1)
 }
 
+// UPDATE
+
+// An UpdateNode is an augmented assignment like `x += 1`.
+type UpdateNode struct {
+  node
+
+  target Assignable
+  operator Operator
+  expr ASTNode
+}
+
+func (n *UpdateNode) Evaluate(runtime *Runtime) (*Value, error) {
+  _, value, err := n.target.Update(runtime, func(old *Value) (*Value, error) {
+    v, err := n.expr.Evaluate(runtime)
+    if err != nil {
+      return nil, err
+    }
+
+    v, err = apply(n.operator, old, v)
+    return v, n.locate(err)
+  })
+
+  return value, err
+}
+
+func (n *UpdateNode) Describe(indent int) {
+  fmt.Printf("# %sUPDATE WITH `%s`:\n", strings.Repeat("  ", indent), n.operator)
+  n.target.Describe(indent+1)
+  fmt.Printf("# %sBY:\n", strings.Repeat("  ", indent))
+  n.expr.Describe(indent+1)
+}
+
+// INCREMENT
+
+type IncrementNode struct {
+  node
+
+  target Assignable
+  delta int
+
+  // prefix increments evaluate to the new value, postfix ones to the old
+  prefix bool
+}
+
+func (n *IncrementNode) Evaluate(runtime *Runtime) (*Value, error) {
+  old, value, err := n.target.Update(runtime, func(old *Value) (*Value, error) {
+    v, err := old.Add(&Value{n.delta, IntType})
+    return v, n.locate(err)
+  })
+
+  if err != nil {
+    return nil, err
+  }
+
+  if n.prefix {
+    return value, nil
+  }
+
+  return old, nil
+}
+
+func (n *IncrementNode) Describe(indent int) {
+  op := "INCREMENT"
+  if n.delta < 0 {
+    op = "DECREMENT"
+  }
+
+  if n.prefix {
+    fmt.Printf("# %sPREFIX %s:\n", strings.Repeat("  ", indent), op)
+  } else {
+    fmt.Printf("# %sPOSTFIX %s:\n", strings.Repeat("  ", indent), op)
+  }
+
+  n.target.Describe(indent+1)
+}
+
+// UNARY
+
+type UnaryNode struct {
+  node
+
+  operator Operator
+  expr ASTNode
+}
+
+func (n *UnaryNode) Evaluate(runtime *Runtime) (*Value, error) {
+  v, err := n.expr.Evaluate(runtime)
+  if err != nil {
+    return nil, err
+  }
+
+  switch n.operator {
+  case NotOp:
+    return v.Not(), nil
+  case NegateOp:
+    v, err := v.Negate()
+    return v, n.locate(err)
+  }
+
+  return NIL, nil
+}
+
+func (n *UnaryNode) Describe(indent int) {
+  var ex string;
+  switch n.operator {
+  case NotOp:
+    ex = "NOT"
+  case NegateOp:
+    ex = "NEGATE"
+  }
+
+  fmt.Printf("# %s%s:\n", strings.Repeat("  ", indent), ex)
+  n.expr.Describe(indent + 1)
+}
+
 // KEYWORD
 
 type Keyword string
 const (
-  ReturnKeyword Keyword = "return"
-  PrintKeyword Keyword  = "print"
+  ReturnKeyword Keyword   = "return"
+  PrintKeyword Keyword    = "print"
+  BreakKeyword Keyword    = "break"
+  ContinueKeyword Keyword = "continue"
+  RaiseKeyword Keyword    = "raise"
 )
 
 type KeywordNode struct {
+  node
+
   keyword Keyword
-  expr ASTNode
+  expr ASTNode // nil for break, continue and a bare return
 }
 
-func (n *KeywordNode) Evaluate(runtime *Runtime) *Value {
+func (n *KeywordNode) Evaluate(runtime *Runtime) (*Value, error) {
   switch n.keyword {
-  //case ReturnKeyword:
-    // TODO
+  case ReturnKeyword:
+    v := NIL
+    if n.expr != nil {
+      var err error
+      v, err = n.expr.Evaluate(runtime)
+      if err != nil {
+        return nil, err
+      }
+    }
+
+    runtime.signal, runtime.returning = ReturnSignal, v
+    return v, nil
   case PrintKeyword:
-    fmt.Printf("%s\n", n.expr.Evaluate(runtime))
+    v, err := n.expr.Evaluate(runtime)
+    if err != nil {
+      return nil, err
+    }
+
+    fmt.Printf("%s\n", v)
+  case RaiseKeyword:
+    v, err := n.expr.Evaluate(runtime)
+    if err != nil {
+      return nil, err
+    }
+
+    return nil, n.locate(raised(v))
+  case BreakKeyword:
+    runtime.signal = BreakSignal
+  case ContinueKeyword:
+    runtime.signal = ContinueSignal
   }
 
-  return NIL
+  return NIL, nil
 }
 
 func (n *KeywordNode) Describe(indent int) {
@@ -165,6 +906,17 @@ func (n *KeywordNode) Describe(indent int) {
     kw = "RETURN"
   case PrintKeyword:
     kw = "PRINT"
+  case BreakKeyword:
+    kw = "BREAK"
+  case ContinueKeyword:
+    kw = "CONTINUE"
+  case RaiseKeyword:
+    kw = "RAISE"
+  }
+
+  if n.expr == nil {
+    fmt.Printf("# %s%s\n", strings.Repeat("  ", indent), kw)
+    return
   }
 
   fmt.Printf("# %s%s:\n", strings.Repeat("  ", indent), kw)
@@ -174,37 +926,54 @@ func (n *KeywordNode) Describe(indent int) {
 // ASSIGN
 
 type AssignNode struct {
-  ident string
+  node
+
+  target Assignable
   expr ASTNode
 }
 
-func (n *AssignNode) Evaluate(runtime *Runtime) *Value {
-  value := n.expr.Evaluate(runtime)
+func (n *AssignNode) Evaluate(runtime *Runtime) (*Value, error) {
+  value, err := n.expr.Evaluate(runtime)
+  if err != nil {
+    return nil, err
+  }
 
-  runtime.ns[n.ident] = value
-  return value
+  return value, n.target.Assign(runtime, value)
 }
 
 func (n *AssignNode) Describe(indent int) {
-  fmt.Printf("# %sASSIGN `%s` to:\n", strings.Repeat("  ", indent), n.ident)
+  if ident, ok := n.target.(*IdentNode); ok {
+    fmt.Printf("# %sASSIGN `%s` to:\n", strings.Repeat("  ", indent), ident.ident)
+  } else {
+    fmt.Printf("# %sASSIGN:\n", strings.Repeat("  ", indent))
+    n.target.Describe(indent+1)
+    fmt.Printf("# %sTO:\n", strings.Repeat("  ", indent))
+  }
+
   n.expr.Describe(indent+1)
 }
 
 // DEF
 
 type DefNode struct {
+  node
+
   ident string
+
+  // the `##` comment lines above the definition
+  doc string
+
   arguments []string
   block *BlockNode
 }
 
-func (n *DefNode) AddArgument(arg string) {
-  n.arguments = append(n.arguments, arg)
-}
+func (n *DefNode) Evaluate(runtime *Runtime) (*Value, error) {
+  block := NewBlock(n.ident, n.arguments, n.block, runtime.scope)
+  block.doc = n.doc
+  value := &Value{block, BlockType}
 
-func (n *DefNode) Evaluate(runtime *Runtime) *Value {
-  // TODO
-  return nil
+  runtime.scope.Define(n.ident, value)
+  return value, nil
 }
 
 func (n *DefNode) Describe(indent int) {
@@ -215,6 +984,40 @@ func (n *DefNode) Describe(indent int) {
     strings.Join(n.arguments, ", "),
   )
 
+  if n.doc != "" {
+    for _, line := range strings.Split(n.doc, "\n") {
+      fmt.Println(strings.TrimRight(fmt.Sprintf("# %s## %s", strings.Repeat("  ", indent+1), line), " "))
+    }
+  }
+
+  for _, child := range n.block.children {
+    child.Describe(indent+1)
+  }
+}
+
+// LAMBDA
+
+// A LambdaNode is a block written as an expression. It has no name, but
+// closes over the scope it's evaluated in just like a definition.
+type LambdaNode struct {
+  node
+
+  arguments []string
+  block *BlockNode
+}
+
+func (n *LambdaNode) Evaluate(runtime *Runtime) (*Value, error) {
+  block := NewBlock("", n.arguments, n.block, runtime.scope)
+  return &Value{block, BlockType}, nil
+}
+
+func (n *LambdaNode) Describe(indent int) {
+  fmt.Printf(
+    "# %sLAMBDA (%s):\n",
+    strings.Repeat("  ", indent),
+    strings.Join(n.arguments, ", "),
+  )
+
   for _, child := range n.block.children {
     child.Describe(indent+1)
   }
@@ -223,16 +1026,40 @@ func (n *DefNode) Describe(indent int) {
 // BLOCK
 
 type BlockNode struct {
+  node
+
   children []ASTNode
 }
 
-func (n *BlockNode) Evaluate(runtime *Runtime) *Value {
-  var last *Value
-  for _, n := range n.children {
-    last = n.Evaluate(runtime)
+func (n *BlockNode) Evaluate(runtime *Runtime) (*Value, error) {
+  start := 0
+  if runtime.resuming() {
+    point, done := runtime.popResume()
+    if done {
+      start = point.index + 1
+    } else {
+      start = point.index
+    }
+  }
+
+  last := NIL
+  for i := start; i < len(n.children); i++ {
+    v, err := n.children[i].Evaluate(runtime)
+    if err != nil {
+      return nil, err
+    }
+
+    last = v
+    if runtime.unwinding() {
+      if runtime.signal == ReturnSignal {
+        runtime.suspend(i)
+      }
+
+      break
+    }
   }
 
-  return last
+  return last, nil
 }
 
 func (n *BlockNode) Describe(indent int) {
@@ -250,6 +1077,8 @@ type CondNode struct {
 }
 
 type BranchNode struct {
+  node
+
   branches []CondNode
   default_branch ASTNode
 }
@@ -258,19 +1087,51 @@ func (n *BranchNode) AddCond(cond ASTNode, then ASTNode) {
   n.branches = append(n.branches, CondNode{cond, then})
 }
 
-func (n *BranchNode) Evaluate(runtime *Runtime) *Value {
-  for _, branch := range n.branches {
-    v := branch.cond.Evaluate(runtime)
+func (n *BranchNode) Evaluate(runtime *Runtime) (*Value, error) {
+  // the default branch is index len(branches) in the resume path
+  if runtime.resuming() {
+    point, done := runtime.popResume()
+    if done {
+      return NIL, nil
+    }
+
+    return n.evaluateBranch(runtime, point.index)
+  }
+
+  for i, branch := range n.branches {
+    v, err := branch.cond.Evaluate(runtime)
+    if err != nil {
+      return nil, err
+    }
+
     if v.IsTruthy() {
-      return branch.then.Evaluate(runtime)
+      return n.evaluateBranch(runtime, i)
     }
   }
 
   if n.default_branch != nil {
-    return n.default_branch.Evaluate(runtime)
+    return n.evaluateBranch(runtime, len(n.branches))
   }
 
-  return NIL
+  return NIL, nil
+}
+
+func (n *BranchNode) evaluateBranch(runtime *Runtime, i int) (*Value, error) {
+  then := n.default_branch
+  if i < len(n.branches) {
+    then = n.branches[i].then
+  }
+
+  v, err := then.Evaluate(runtime)
+  if err != nil {
+    return nil, err
+  }
+
+  if runtime.signal == ReturnSignal {
+    runtime.suspend(i)
+  }
+
+  return v, nil
 }
 
 func (n *BranchNode) Describe(indent int) {
@@ -295,3 +1156,304 @@ func (n *BranchNode) Describe(indent int) {
     n.default_branch.Describe(indent+1)
   }
 }
+
+// TRY
+
+// The sections of a try, as they're numbered in the resume path.
+const (
+  tryBody = iota
+  tryRescue
+  tryEnsure
+)
+
+// A TryNode runs its body, and the rescue if the body fails. The ensure runs
+// however the others finish. Returning runs it too, on the way out, and
+// suspends the block inside the try to carry on when it's resumed.
+type TryNode struct {
+  node
+
+  body ASTNode
+
+  // the name the error is given in the rescue, if any
+  ident string
+  rescue ASTNode
+
+  ensure ASTNode
+}
+
+func (n *TryNode) Evaluate(runtime *Runtime) (*Value, error) {
+  section := tryBody
+  if runtime.resuming() {
+    point, _ := runtime.popResume()
+    section = point.index
+  }
+
+  v, err := NIL, error(nil)
+  if section == tryBody {
+    v, err = n.body.Evaluate(runtime)
+    if n.returned(runtime, err) {
+      return n.leave(runtime, v, tryBody)
+    }
+
+    if e, ok := err.(*RuntimeError); ok && n.rescue != nil {
+      if n.ident != "" {
+        runtime.scope.Set(n.ident, &Value{e, ErrorType})
+      }
+
+      section = tryRescue
+    }
+  }
+
+  if section == tryRescue {
+    v, err = n.rescue.Evaluate(runtime)
+    if n.returned(runtime, err) {
+      return n.leave(runtime, v, tryRescue)
+    }
+  }
+
+  if n.ensure == nil {
+    return v, err
+  }
+
+  // a break or continue waits until the ensure is done
+  signal, returning := runtime.signal, runtime.returning
+  if section != tryEnsure {
+    runtime.signal, runtime.returning = NoSignal, nil
+  }
+
+  ensured, ensure_err := n.ensure.Evaluate(runtime)
+  if ensure_err != nil {
+    return nil, ensure_err
+  }
+
+  // leaving the ensure early takes the place of whatever was pending, like
+  // an error from the body
+  if n.returned(runtime, nil) {
+    runtime.suspend(tryEnsure)
+    return ensured, nil
+  } else if runtime.unwinding() {
+    return NIL, nil
+  }
+
+  runtime.signal, runtime.returning = signal, returning
+  return v, err
+}
+
+// returned reports whether a section stopped at a return.
+func (n *TryNode) returned(runtime *Runtime, err error) bool {
+  return err == nil && runtime.signal == ReturnSignal
+}
+
+// leave runs the ensure on the way out of a return from the body or rescue,
+// then suspends the block inside that section. The resume path built so far is
+// put aside while the ensure runs, so it doesn't look like it's resuming.
+func (n *TryNode) leave(runtime *Runtime, v *Value, section int) (*Value, error) {
+  if n.ensure == nil {
+    runtime.suspend(section)
+    return v, nil
+  }
+
+  path, returning := runtime.resume, runtime.returning
+  runtime.signal, runtime.returning, runtime.resume = NoSignal, nil, nil
+
+  ensured, err := n.ensure.Evaluate(runtime)
+  if err != nil {
+    return nil, err
+  }
+
+  // as with errors, leaving the ensure early takes the place of the return
+  if n.returned(runtime, nil) {
+    runtime.suspend(tryEnsure)
+    return ensured, nil
+  } else if runtime.unwinding() {
+    return NIL, nil
+  }
+
+  runtime.signal, runtime.returning, runtime.resume = ReturnSignal, returning, path
+  runtime.suspend(section)
+  return v, nil
+}
+
+func (n *TryNode) Describe(indent int) {
+  fmt.Printf("# %sTRY:\n", strings.Repeat("  ", indent))
+  n.body.Describe(indent+1)
+
+  if n.rescue != nil {
+    if n.ident != "" {
+      fmt.Printf("# %sRESCUE `%s`:\n", strings.Repeat("  ", indent), n.ident)
+    } else {
+      fmt.Printf("# %sRESCUE:\n", strings.Repeat("  ", indent))
+    }
+
+    n.rescue.Describe(indent+1)
+  }
+
+  if n.ensure != nil {
+    fmt.Printf("# %sENSURE:\n", strings.Repeat("  ", indent))
+    n.ensure.Describe(indent+1)
+  }
+}
+
+// LOOP
+
+type LoopNode struct {
+  node
+
+  body ASTNode
+}
+
+func (n *LoopNode) Evaluate(runtime *Runtime) (*Value, error) {
+  again := true
+  if runtime.resuming() {
+    _, done := runtime.popResume()
+
+    // unless the body was the return itself, finish off the iteration that
+    // was interrupted
+    if !done {
+      var err error
+      again, err = n.iterate(runtime)
+      if err != nil {
+        return nil, err
+      }
+    }
+  }
+
+  for again {
+    var err error
+    again, err = n.iterate(runtime)
+    if err != nil {
+      return nil, err
+    }
+  }
+
+  return NIL, nil
+}
+
+// iterate runs the body once, and reports whether to go around again.
+func (n *LoopNode) iterate(runtime *Runtime) (bool, error) {
+  _, err := n.body.Evaluate(runtime)
+  if err != nil {
+    return false, err
+  }
+
+  switch runtime.signal {
+  case BreakSignal:
+    runtime.signal = NoSignal
+    return false, nil
+  case ContinueSignal:
+    runtime.signal = NoSignal
+  case ReturnSignal:
+    runtime.suspend(0)
+    return false, nil
+  }
+
+  return true, nil
+}
+
+func (n *LoopNode) Describe(indent int) {
+  fmt.Printf("# %sFOREVER:\n", strings.Repeat("  ", indent))
+  n.body.Describe(indent+1)
+}
+
+// FOR
+
+type ForNode struct {
+  node
+
+  ident string
+  iterable ASTNode
+  body ASTNode
+
+  // the postfix form collects the body's values into a list
+  collect bool
+}
+
+func (n *ForNode) Evaluate(runtime *Runtime) (*Value, error) {
+  results := &List{}
+
+  var iter Iterator
+  if runtime.resuming() {
+    point, done := runtime.popResume()
+    iter = point.iter
+
+    if !done {
+      again, err := n.iterate(runtime, iter, nil, results)
+      if err != nil || !again {
+        return n.result(results), err
+      }
+    }
+  } else {
+    v, err := n.iterable.Evaluate(runtime)
+    if err != nil {
+      return nil, err
+    }
+
+    iter = v.Iterate()
+    if iter == nil {
+      return nil, n.fail(TypeError, "Can't iterate over %s", v.val_type)
+    }
+  }
+
+  for {
+    item, ok, err := iter.Next(runtime)
+    if err != nil {
+      return nil, n.locate(err)
+    } else if !ok {
+      break
+    }
+
+    again, err := n.iterate(runtime, iter, item, results)
+    if err != nil {
+      return nil, err
+    } else if !again {
+      break
+    }
+  }
+
+  return n.result(results), nil
+}
+
+// iterate runs the body for one item, or finishes the interrupted iteration if
+// item is nil, and reports whether to go on to the next.
+func (n *ForNode) iterate(runtime *Runtime, iter Iterator, item *Value, results *List) (bool, error) {
+  if item != nil {
+    runtime.scope.Set(n.ident, item)
+  }
+
+  v, err := n.body.Evaluate(runtime)
+  if err != nil {
+    return false, err
+  }
+
+  if n.collect && !runtime.unwinding() {
+    results.Append(v)
+  }
+
+  switch runtime.signal {
+  case BreakSignal:
+    runtime.signal = NoSignal
+    return false, nil
+  case ContinueSignal:
+    runtime.signal = NoSignal
+  case ReturnSignal:
+    runtime.suspendIterator(iter)
+    return false, nil
+  }
+
+  return true, nil
+}
+
+func (n *ForNode) result(results *List) *Value {
+  if n.collect {
+    return &Value{results, ListType}
+  }
+
+  return NIL
+}
+
+func (n *ForNode) Describe(indent int) {
+  fmt.Printf("# %sFOR `%s` IN:\n", strings.Repeat("  ", indent), n.ident)
+  n.iterable.Describe(indent+1)
+  fmt.Printf("# %sDO:\n", strings.Repeat("  ", indent))
+  n.body.Describe(indent+1)
+}
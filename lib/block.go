@@ -0,0 +1,333 @@
+package goon
+
+import "sync"
+
+// A Block is a generator. Calling it runs the body until a return, which
+// hands back a value and suspends the block right after that statement; the
+// next call picks up from there with the same locals. Once a block falls off
+// the end it is finished, and calling it again starts it over.
+type Block struct {
+  ident string
+  doc string
+  arguments []string
+  body *BlockNode
+
+  // the scope the block was defined in
+  scope *Scope
+
+  // the locals of the current (or last) run, and where to resume it. They
+  // can be read by others while the block runs in the background, so they're
+  // guarded by the lock along with running.
+  locals *Scope
+  resume []resumePoint
+
+  running bool
+  lock sync.Mutex
+}
+
+func NewBlock(ident string, arguments []string, body *BlockNode, scope *Scope) *Block {
+  return &Block{ident: ident, arguments: arguments, body: body, scope: scope}
+}
+
+// name is what the block is called in errors. Blocks written as expressions
+// don't have one of their own.
+func (b *Block) name() string {
+  if b.ident == "" {
+    return "<anonymous>"
+  }
+
+  return b.ident
+}
+
+// fresh returns a new instance of the block, which hasn't been run yet.
+func (b *Block) fresh() *Block {
+  f := NewBlock(b.ident, b.arguments, b.body, b.scope)
+  f.doc = b.doc
+  return f
+}
+
+// Fork copies the block along with its locals and the point it's suspended
+// at, so the copy carries on independently of the original. Blocks defined
+// inside it are forked too, and rebound to the copied locals.
+func (b *Block) Fork() *Block {
+  f := &forker{
+    make(map[*Scope]*Scope),
+    make(map[*Block]*Block),
+    make(map[*List]*List),
+    make(map[*Map]*Map),
+  }
+
+  return f.block(b)
+}
+
+func (b *Block) Suspended() bool {
+  b.lock.Lock()
+  defer b.lock.Unlock()
+
+  return len(b.resume) > 0
+}
+
+// state returns the locals and resume path as they are now.
+func (b *Block) state() (*Scope, []resumePoint) {
+  b.lock.Lock()
+  defer b.lock.Unlock()
+
+  return b.locals, b.resume
+}
+
+// members returns the locals, for setting them from outside the block. A
+// block that hasn't run yet gets them made up front.
+func (b *Block) members() *Scope {
+  b.lock.Lock()
+  defer b.lock.Unlock()
+
+  if b.locals == nil {
+    b.locals = NewScope(b.scope)
+  }
+
+  return b.locals
+}
+
+func (b *Block) Call(runtime *Runtime, args []*Value) (*Value, error) {
+  value, _, err := b.call(runtime, args)
+  return value, err
+}
+
+// call runs the block, and returns the instance that ran along with the value.
+func (b *Block) call(runtime *Runtime, args []*Value) (*Value, *Block, error) {
+  // a block that's already running - because it called itself, or because
+  // it was called in the background - gets a fresh instance, so the calls
+  // don't trample each other's generator state
+  if !b.acquire() {
+    return b.fresh().call(runtime, args)
+  }
+  defer b.release()
+
+  resumed := b.Suspended()
+  value, returned, err := b.run(runtime, args)
+  if err != nil {
+    return nil, b, err
+  }
+
+  // resuming ran off the end, so start over rather than hand back nothing
+  if resumed && !returned {
+    value, _, err = b.run(runtime, args)
+  }
+
+  return value, b, err
+}
+
+// Next runs the block on to its next return, to iterate over it as a
+// generator. It reports false once the block falls off the end.
+func (b *Block) Next(runtime *Runtime) (*Value, bool, error) {
+  value, _, returned, err := b.next(runtime)
+  return value, returned, err
+}
+
+// next is Next, but also returns the instance that ran. Like a call, a block
+// that's already running hands over to a fresh instance.
+func (b *Block) next(runtime *Runtime) (*Value, *Block, bool, error) {
+  if !b.acquire() {
+    return b.fresh().next(runtime)
+  }
+  defer b.release()
+
+  value, returned, err := b.run(runtime, nil)
+  return value, b, returned, err
+}
+
+func (b *Block) acquire() bool {
+  b.lock.Lock()
+  defer b.lock.Unlock()
+
+  if b.running {
+    return false
+  }
+
+  b.running = true
+  return true
+}
+
+func (b *Block) release() {
+  b.lock.Lock()
+  b.running = false
+  b.lock.Unlock()
+}
+
+// run executes the block from wherever it left off, and reports whether it
+// stopped at a return rather than finishing. A nil args resumes the block
+// with its arguments as they were. An error finishes the block.
+func (b *Block) run(runtime *Runtime, args []*Value) (*Value, bool, error) {
+  if runtime.depth >= MaxDepth {
+    return nil, false, NewError(RecursionError, "Calls nested more than %d deep", MaxDepth)
+  }
+  runtime.depth++
+  defer func() { runtime.depth-- }()
+
+  b.lock.Lock()
+  fresh := len(b.resume) == 0
+  if fresh {
+    b.locals = NewScope(b.scope)
+  }
+  // the runtime gets its own copy of the path to resume along, since it's
+  // used up as the block runs while Fork can still be reading the original
+  locals, resume := b.locals, copyResume(b.resume)
+  b.lock.Unlock()
+
+  if args != nil || fresh {
+    for i, arg := range b.arguments {
+      if i < len(args) {
+        locals.Define(arg, args[i])
+      } else {
+        locals.Define(arg, NIL)
+      }
+    }
+  }
+
+  caller_scope, caller_resume := runtime.scope, runtime.resume
+  runtime.scope, runtime.resume = locals, resume
+
+  last, err := b.body.Evaluate(runtime)
+
+  resume = nil
+
+  returned := runtime.signal == ReturnSignal
+  if returned && err == nil {
+    last = runtime.returning
+    resume = runtime.resume
+  }
+
+  b.lock.Lock()
+  b.resume = resume
+  b.lock.Unlock()
+
+  // the parser keeps break and continue inside loops, so only a return is
+  // left to clear
+  runtime.signal, runtime.returning = NoSignal, nil
+
+  runtime.scope, runtime.resume = caller_scope, caller_resume
+
+  if err != nil {
+    return nil, false, unwind(err, b.name())
+  }
+
+  return last, returned, nil
+}
+
+type forker struct {
+  scopes map[*Scope]*Scope
+  blocks map[*Block]*Block
+  lists map[*List]*List
+  maps map[*Map]*Map
+}
+
+func (f *forker) block(b *Block) *Block {
+  if forked, present := f.blocks[b]; present {
+    return forked
+  }
+
+  forked := b.fresh()
+  if s, present := f.scopes[b.scope]; present {
+    forked.scope = s
+  }
+  f.blocks[b] = forked
+
+  locals, resume := b.state()
+
+  forked.resume = make([]resumePoint, len(resume))
+  for i, point := range resume {
+    forked.resume[i] = resumePoint{point.index, f.iterator(point.iter)}
+  }
+
+  if locals != nil {
+    forked.locals = f.scope(locals)
+  }
+
+  return forked
+}
+
+func (f *forker) scope(s *Scope) *Scope {
+  if forked, present := f.scopes[s]; present {
+    return forked
+  }
+
+  parent := s.parent
+  if p, present := f.scopes[parent]; present {
+    parent = p
+  }
+
+  forked := NewScope(parent)
+  f.scopes[s] = forked
+
+  s.Each(func(name string, v *Value) {
+    forked.vars[name] = f.value(v)
+  })
+
+  return forked
+}
+
+// value copies lists and maps, and forks blocks that close over state being forked.
+// Anything else is immutable or belongs to someone else, and is shared with
+// the original.
+func (f *forker) value(v *Value) *Value {
+  switch v.val_type {
+  case BlockType:
+    b := v.val.(*Block)
+    if _, present := f.scopes[b.scope]; present {
+      return &Value{f.block(b), BlockType}
+    }
+  case ListType:
+    return &Value{f.list(v.val.(*List)), ListType}
+  case MapType:
+    return &Value{f.dict(v.val.(*Map)), MapType}
+  }
+
+  return v
+}
+
+func (f *forker) dict(m *Map) *Map {
+  if forked, present := f.maps[m]; present {
+    return forked
+  }
+
+  forked := NewMap()
+  f.maps[m] = forked
+
+  for _, k := range m.Keys() {
+    v, _ := m.Get(k)
+    forked.Set(k, f.value(v))
+  }
+
+  return forked
+}
+
+func (f *forker) list(l *List) *List {
+  if forked, present := f.lists[l]; present {
+    return forked
+  }
+
+  forked := &List{}
+  f.lists[l] = forked
+
+  for i := 0; i < l.Len(); i++ {
+    forked.Append(f.value(l.Get(i)))
+  }
+
+  return forked
+}
+
+func (f *forker) iterator(it Iterator) Iterator {
+  switch it := it.(type) {
+  case *listIterator:
+    return &listIterator{f.list(it.list), it.i}
+  case *stringIterator:
+    forked := *it
+    return &forked
+  case *blockIterator:
+    if _, present := f.scopes[it.block.scope]; present {
+      return &blockIterator{f.block(it.block)}
+    }
+  }
+
+  return it
+}
@@ -0,0 +1,28 @@
+package goon
+
+// builtins are the functions every program can call. They're defined as
+// globals, so a program can still use their names for its own.
+var builtins = map[string]func(runtime *Runtime, args []*Value) (*Value, error){
+  "doc": builtinDoc,
+}
+
+func defineBuiltins(scope *Scope) {
+  for name, fn := range builtins {
+    scope.Define(name, &Value{&Builtin{name, fn}, BuiltinType})
+  }
+}
+
+// doc(block) returns the doc comment written above the block's definition, or
+// nil if it doesn't have one.
+func builtinDoc(runtime *Runtime, args []*Value) (*Value, error) {
+  if len(args) != 1 || args[0].val_type != BlockType {
+    return nil, NewError(TypeError, "doc needs a block")
+  }
+
+  doc := args[0].val.(*Block).doc
+  if doc == "" {
+    return NIL, nil
+  }
+
+  return &Value{doc, StringType}, nil
+}
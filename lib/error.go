@@ -0,0 +1,86 @@
+package goon
+
+import (
+  "fmt"
+  "strings"
+)
+
+type ErrorKind string
+const (
+  NameError ErrorKind         = "NameError"
+  TypeError ErrorKind         = "TypeError"
+  IndexError ErrorKind        = "IndexError"
+  ZeroDivisionError ErrorKind = "ZeroDivisionError"
+  OverflowError ErrorKind     = "OverflowError"
+  RecursionError ErrorKind    = "RecursionError"
+
+  // raised by a program with a message
+  RaisedError ErrorKind       = "Error"
+)
+
+// A RuntimeError is what goes wrong while a program runs, like adding a string
+// to an int. It's handed back up through Evaluate until something deals with
+// it.
+type RuntimeError struct {
+  Kind ErrorKind
+  Message string
+  Span Span
+
+  // the blocks the error passed through on its way out, innermost first
+  Stack []Frame
+
+  // where the error has got to in the block it's passing out of
+  at Span
+}
+
+// A Frame is a block an error passed through, and where in it the error was.
+type Frame struct {
+  Ident string
+  Span Span
+}
+
+func NewError(kind ErrorKind, format string, args ...interface{}) *RuntimeError {
+  return &RuntimeError{Kind: kind, Message: fmt.Sprintf(format, args...)}
+}
+
+func (e *RuntimeError) Error() string {
+  return located(e.Span, fmt.Sprintf("%s: %s", e.Kind, e.Message))
+}
+
+// Trace lists the blocks the error passed through, a line each.
+func (e *RuntimeError) Trace() string {
+  var b strings.Builder
+  for _, frame := range e.Stack {
+    if frame.Span.Known() {
+      fmt.Fprintf(&b, "  in %s at %s\n", frame.Ident, frame.Span)
+    } else {
+      fmt.Fprintf(&b, "  in %s\n", frame.Ident)
+    }
+  }
+
+  return b.String()
+}
+
+// raised turns the value of a raise statement into the error to raise. Strings
+// become the message of a new error, and rescued errors are raised again.
+func raised(v *Value) error {
+  switch v.val_type {
+  case StringType:
+    return NewError(RaisedError, "%s", v.val.(string))
+  case ErrorType:
+    e := *v.val.(*RuntimeError)
+    e.Stack, e.at = nil, Span{}
+    return &e
+  }
+
+  return NewError(TypeError, "Can't raise %s", v.val_type)
+}
+
+// unwind records that the error passed out through a block.
+func unwind(err error, ident string) error {
+  if e, ok := err.(*RuntimeError); ok {
+    e.Stack = append(e.Stack, Frame{ident, e.at})
+  }
+
+  return err
+}
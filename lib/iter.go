@@ -0,0 +1,95 @@
+package goon
+
+// Iterators step through the items of a value for `for` loops.
+type Iterator interface {
+  Next(runtime *Runtime) (*Value, bool, error)
+}
+
+// Iterate returns an iterator over the value, or nil if it can't be iterated.
+// Maps are iterated over their keys as they were when the loop started, and
+// blocks are iterated as generators, by resuming them until they finish.
+func (v *Value) Iterate() Iterator {
+  if v == nil {
+    return nil
+  }
+
+  switch v.val_type {
+  case ListType:
+    return &listIterator{v.val.(*List), 0}
+  case StringType:
+    return &stringIterator{[]rune(v.val.(string)), 0}
+  case MapType:
+    return &listIterator{&List{items: v.val.(*Map).Keys()}, 0}
+  case BlockType:
+    return &blockIterator{v.val.(*Block)}
+  }
+
+  return nil
+}
+
+// cloneIterator returns an iterator that carries on from where it is, without
+// moving it along.
+func cloneIterator(it Iterator) Iterator {
+  switch it := it.(type) {
+  case *listIterator:
+    cloned := *it
+    return &cloned
+  case *stringIterator:
+    cloned := *it
+    return &cloned
+  case *blockIterator:
+    cloned := *it
+    return &cloned
+  }
+
+  return it
+}
+
+type listIterator struct {
+  list *List
+  i int
+}
+
+func (it *listIterator) Next(runtime *Runtime) (*Value, bool, error) {
+  if it.i >= it.list.Len() {
+    return nil, false, nil
+  }
+
+  v := it.list.Get(it.i)
+  it.i++
+
+  return v, true, nil
+}
+
+// strings are iterated a character at a time
+type stringIterator struct {
+  runes []rune
+  i int
+}
+
+func (it *stringIterator) Next(runtime *Runtime) (*Value, bool, error) {
+  if it.i >= len(it.runes) {
+    return nil, false, nil
+  }
+
+  v := &Value{string(it.runes[it.i]), StringType}
+  it.i++
+
+  return v, true, nil
+}
+
+// blocks are iterated by resuming them. One that's already running is swapped
+// for the fresh instance that runs instead, and iterated from then on.
+type blockIterator struct {
+  block *Block
+}
+
+func (it *blockIterator) Next(runtime *Runtime) (*Value, bool, error) {
+  v, block, returned, err := it.block.next(runtime)
+  it.block = block
+  if err != nil || !returned {
+    return nil, false, err
+  }
+
+  return v, true, nil
+}
@@ -4,14 +4,20 @@ import (
   "strings"
   "unicode"
   "fmt"
+  "math/big"
+  "unicode/utf8"
 )
 
 const (
   digits string = "0123456789"
-  symbols string = "+-*/=(),:"
+  symbols string = "+-*/%^=(),:.[]{}<>!"
   eof rune = -1
 )
 
+// DefaultTabWidth is how many columns a tab indents to, unless Lex is told
+// otherwise.
+const DefaultTabWidth = 8
+
 func in(r rune, s string) bool {
   return (strings.IndexRune(s, r) >= 0)
 }
@@ -26,11 +32,19 @@ const (
   TrueLexeme
   FalseLexeme
   NumberLexeme
+  FloatLexeme
+  StringLexeme
 
   IdentLexeme
   MethodIdentLexeme
 
   AssignLexeme
+  AddAssignLexeme
+  SubtractAssignLexeme
+  MultiplyAssignLexeme
+  DivideAssignLexeme
+  IncrementLexeme
+  DecrementLexeme
 
   AndLexeme
   OrLexeme
@@ -39,12 +53,24 @@ const (
   SubtractLexeme
   MultiplyLexeme
   DivideLexeme
+  IntDivideLexeme
+  ModuloLexeme
+  PowerLexeme
   CompareLexeme
   InvCompareLexeme
+  LessLexeme
+  LessEqualLexeme
+  GreaterLexeme
+  GreaterEqualLexeme
+  NotLexeme
   OperatorLexeme
 
   LeftParenLexeme
   RightParenLexeme
+  LeftBracketLexeme
+  RightBracketLexeme
+  LeftBraceLexeme
+  RightBraceLexeme
 
   IfLexeme
   UnlessLexeme
@@ -53,46 +79,93 @@ const (
 
   ThenLexeme
   CommaLexeme
+  DotLexeme
+  EllipsisLexeme
   DefLexeme
 
   ReturnLexeme
   PrintLexeme
+  NewLexeme
+
+  ForeverLexeme
+  ForLexeme
+  InLexeme
+  BreakLexeme
+  ContinueLexeme
+  TryLexeme
+  RescueLexeme
+  EnsureLexeme
+  RaiseLexeme
+
+  DocCommentLexeme
 
   SpaceLexeme
   IndentLexeme
+  DedentLexeme
+  IndentErrLexeme
   EOLLexeme
   EOFLexeme
 )
 
 var symbol_map = map[rune]LexemeType{
   '=': AssignLexeme,
+  '<': LessLexeme,
+  '>': GreaterLexeme,
   '+': AddLexeme,
   '-': SubtractLexeme,
   '*': MultiplyLexeme,
   '/': DivideLexeme,
+  '%': ModuloLexeme,
+  '^': PowerLexeme,
   '(': LeftParenLexeme,
   ')': RightParenLexeme,
+  '[': LeftBracketLexeme,
+  ']': RightBracketLexeme,
+  '{': LeftBraceLexeme,
+  '}': RightBraceLexeme,
   ',': CommaLexeme,
+  '.': DotLexeme,
   ':': ThenLexeme,
 }
 
+// update_map has the operators that can be followed by `=` to update a
+// variable in place
+var update_map = map[rune]LexemeType{
+  '+': AddAssignLexeme,
+  '-': SubtractAssignLexeme,
+  '*': MultiplyAssignLexeme,
+  '/': DivideAssignLexeme,
+}
+
 var keyword_map = map[string]LexemeType{
-  "nil":    NilLexeme,
-  "true":   TrueLexeme,
-  "false":  FalseLexeme,
-  "and":    AndLexeme,
-  "or":     OrLexeme,
-  "if":     IfLexeme,
-  "unless": UnlessLexeme,
-  "elif":   ElifLexeme,
-  "else":   ElseLexeme,
-  "print":  PrintLexeme,
-  "return": ReturnLexeme,
+  "nil":      NilLexeme,
+  "true":     TrueLexeme,
+  "false":    FalseLexeme,
+  "and":      AndLexeme,
+  "or":       OrLexeme,
+  "not":      NotLexeme,
+  "if":       IfLexeme,
+  "unless":   UnlessLexeme,
+  "elif":     ElifLexeme,
+  "else":     ElseLexeme,
+  "print":    PrintLexeme,
+  "return":   ReturnLexeme,
+  "new":      NewLexeme,
+  "forever":  ForeverLexeme,
+  "for":      ForLexeme,
+  "in":       InLexeme,
+  "break":    BreakLexeme,
+  "continue": ContinueLexeme,
+  "try":      TryLexeme,
+  "rescue":   RescueLexeme,
+  "ensure":   EnsureLexeme,
+  "raise":    RaiseLexeme,
 }
 
 type Lexeme struct {
   lexeme_type LexemeType
   value string
+  span Span
 }
 
 func (l *Lexeme) String() string {
@@ -101,7 +174,11 @@ func (l *Lexeme) String() string {
   } else if l.lexeme_type == EOLLexeme {
     return "EOL"
   } else if l.lexeme_type == IndentLexeme {
-    return fmt.Sprintf("INDENT(%d)", len(l.value))
+    return "INDENT"
+  } else if l.lexeme_type == DedentLexeme {
+    return "DEDENT"
+  } else if l.lexeme_type == IndentErrLexeme {
+    return l.value
   } else if l.lexeme_type == PrintLexeme {
     return "PRINT"
   }
@@ -110,12 +187,60 @@ func (l *Lexeme) String() string {
 }
 
 type Lexer struct {
+  source *Source
   input []rune
   window struct {
     start int
     end int
   }
-  stream chan Lexeme
+
+  // where the window starts, and where in the text it starts in bytes
+  start Position
+  offset int
+
+  // the input again as a string, to cut lexeme values out of
+  text string
+
+  // the type of the last lexeme emitted
+  last LexemeType
+
+  // the indentation of the blocks the lexer is in, innermost last, and how
+  // many columns a tab indents to
+  indents []indent
+  tabWidth int
+
+  // how many brackets are open. Lines inside brackets carry on onto the
+  // next, and their indentation doesn't count.
+  depth int
+
+  // blocks opened by a `->` at the end of a line inside brackets, innermost
+  // last. Their lines count as lines until they close.
+  nested []nesting
+
+  // doc comments waiting to go out after the next line's indentation
+  docs []Lexeme
+
+  // the state to run next, and the lexemes it's emitted that haven't been
+  // asked for yet
+  state LexFn
+  queue []Lexeme
+  head int
+}
+
+// An indent is how far a line is indented. It's measured with tabs going to
+// the next multiple of the tab width, and again with tabs as one column; if the two
+// don't agree on which of two lines is indented further, tabs and spaces have
+// been mixed up.
+type indent struct {
+  columns int
+  runes int
+}
+
+// A nesting is a block inside brackets. It keeps how many brackets were open,
+// and how many indentation levels there were when it started.
+type nesting struct {
+  depth int
+  level int
 }
 
 func (l *Lexer) peek() rune {
@@ -125,6 +250,14 @@ func (l *Lexer) peek() rune {
   return l.input[l.window.end]
 }
 
+// lookahead returns the rune i places past the end of the window.
+func (l *Lexer) lookahead(i int) rune {
+  if l.window.end + i >= len(l.input) {
+    return eof
+  }
+  return l.input[l.window.end + i]
+}
+
 func (l *Lexer) expand() {
   l.window.end += 1
 }
@@ -135,6 +268,8 @@ func (l *Lexer) skip() {
 }
 
 func (l *Lexer) discard() {
+  l.start = l.start.advance(l.current())
+  l.offset += l.width()
   l.window.start = l.window.end
 }
 
@@ -142,12 +277,52 @@ func (l *Lexer) current() []rune {
   return l.input[l.window.start:l.window.end]
 }
 
-func (l *Lexer) emit(lexeme_type LexemeType) {
+// width returns how many bytes the window takes up in the text.
+func (l *Lexer) width() int {
+  n := 0
+  for _, r := range l.current() {
+    n += utf8.RuneLen(r)
+  }
+
+  return n
+}
+
+// expandLine takes in the rest of the line, up to the newline.
+func (l *Lexer) expandLine() {
+  for l.peek() != '\n' && l.peek() != eof {
+    l.expand()
+  }
+}
+
+// lexeme returns the window as a lexeme, without emitting it.
+func (l *Lexer) lexeme(lexeme_type LexemeType) Lexeme {
   chunk := l.current()
-  l.stream <- Lexeme{lexeme_type, string(chunk)}
+  span := Span{l.source, l.start, l.start.advance(chunk)}
+
+  // cut from the text rather than made from the runes, so it doesn't allocate
+  value := l.text[l.offset:l.offset + l.width()]
+  return Lexeme{lexeme_type, value, span}
+}
+
+func (l *Lexer) emit(lexeme_type LexemeType) {
+  l.send(l.lexeme(lexeme_type))
   l.discard()
 }
 
+func (l *Lexer) send(lexeme Lexeme) {
+  switch lexeme.lexeme_type {
+  case LeftParenLexeme, LeftBracketLexeme, LeftBraceLexeme:
+    l.depth++
+  case RightParenLexeme, RightBracketLexeme, RightBraceLexeme:
+    if l.depth > 0 {
+      l.depth--
+    }
+  }
+
+  l.queue = append(l.queue, lexeme)
+  l.last = lexeme.lexeme_type
+}
+
 func lexStart(l *Lexer) LexFn {
   return lexIndent
 }
@@ -159,16 +334,27 @@ func lexCode(l *Lexer) LexFn {
     r := l.peek()
 
     if r == eof {
-      l.emit(EOFLexeme)
-      return nil
+      return lexEnd
+    } else if r == '\n' && l.depth > 0 && l.last != DefLexeme {
+      l.skip()
     } else if r == '\n' {
+      // a block inside brackets is lexed like any other, until it closes
+      if l.depth > 0 {
+        l.nested = append(l.nested, nesting{l.depth, len(l.indents)})
+        l.depth = 0
+      }
+
       l.expand()
       l.emit(EOLLexeme)
       return lexIndent
-    } else if r == ' ' {
+    } else if r == ' ' || r == '\t' {
       l.skip()
+    } else if r == '#' {
+      return lexComment
     } else if in(r, digits) {
       return lexNumber
+    } else if r == '\'' || r == '"' {
+      return lexString
     } else if in(r, symbols) {
       return lexSymbol
     } else if unicode.IsLetter(r) || r == '_' {
@@ -178,31 +364,231 @@ func lexCode(l *Lexer) LexFn {
       l.emit(ErrLexeme)
     }
   }
-
-  return lexStart
 }
 
+// lexIndent measures the indentation at the start of a line. Blank lines and
+// lines with only a comment on them don't count, so they're skipped here,
+// though doc comments are kept to send on with the next line.
 func lexIndent(l *Lexer) LexFn {
   for {
-    if l.peek() != ' ' {
-      break
+    level := indent{}
+    for l.peek() == ' ' || l.peek() == '\t' {
+      if l.peek() == '\t' {
+        level.columns += l.tabWidth - level.columns % l.tabWidth
+      } else {
+        level.columns++
+      }
+
+      level.runes++
+      l.expand()
     }
 
-    l.expand()
+    r := l.peek()
+    if r == eof {
+      l.discard()
+      return lexEnd
+    } else if r == '\n' {
+      l.skip()
+    } else if r == '#' {
+      l.discard()
+      l.expandLine()
+      if strings.HasPrefix(string(l.current()), "##") {
+        l.docs = append(l.docs, l.lexeme(DocCommentLexeme))
+      }
+      l.discard()
+    } else {
+      return l.indent(level)
+    }
+  }
+}
+
+// indent emits an INDENT if the line is indented further than the one before
+// it, or a DEDENT for every block it closes, followed by any doc comments that
+// came before the line. Bad indentation is sent as an error, placed so that it
+// belongs to the block the line would have been in.
+func (l *Lexer) indent(level indent) LexFn {
+  bad := l.lexeme(IndentErrLexeme)
+  bad.value = "Inconsistent use of tabs and spaces in indentation"
+  top := l.indents[len(l.indents)-1]
+
+  if level.columns > top.columns {
+    if level.runes <= top.runes {
+      l.send(bad)
+    }
+
+    l.indents = append(l.indents, level)
+    l.emit(IndentLexeme)
+  } else {
+    l.discard()
+    l.dedent(level, bad)
   }
 
-  l.emit(IndentLexeme)
+  for _, doc := range l.docs {
+    l.send(doc)
+  }
+  l.docs = nil
+
+  return lexCode
+}
+
+// dedent emits a DEDENT for every block the line closes. Once that closes a
+// block inside brackets, the brackets carry on, and the rest of the line's
+// indentation doesn't matter.
+func (l *Lexer) dedent(level indent, bad Lexeme) {
+  if l.unnest() {
+    return
+  }
+
+  top := l.indents[len(l.indents)-1]
+  for level.columns < top.columns {
+    l.indents = l.indents[:len(l.indents)-1]
+    top = l.indents[len(l.indents)-1]
+    l.emit(DedentLexeme)
+
+    if l.unnest() {
+      return
+    }
+  }
 
+  if level.columns != top.columns {
+    bad.value = "Dedent doesn't match any outer indentation"
+    l.send(bad)
+  } else if level.runes != top.runes {
+    l.send(bad)
+  }
+}
+
+// unnest goes back into the brackets around the innermost block opened in
+// them, if the indentation is back out to where that block started, and
+// reports whether it did. A block whose first line isn't indented ends
+// straight away.
+func (l *Lexer) unnest() bool {
+  end := len(l.nested)-1
+  if end < 0 || l.nested[end].level != len(l.indents) {
+    return false
+  }
+
+  l.depth = l.nested[end].depth
+  l.nested = l.nested[:end]
+  return true
+}
+
+// lexComment skips a comment, which runs to the end of the line. Comments on
+// lines of their own are dealt with by lexIndent.
+func lexComment(l *Lexer) LexFn {
+  l.expandLine()
+  l.discard()
   return lexCode
 }
 
+// lexEnd finishes off the last line, and closes any blocks still open.
+func lexEnd(l *Lexer) LexFn {
+  if l.last != EOLLexeme {
+    l.emit(EOLLexeme)
+  }
+
+  for len(l.indents) > 1 {
+    l.indents = l.indents[:len(l.indents)-1]
+    l.emit(DedentLexeme)
+  }
+
+  l.emit(EOFLexeme)
+  return nil
+}
+
+var radix_digits = map[rune]string{
+  'x': "0123456789abcdefABCDEF",
+  'X': "0123456789abcdefABCDEF",
+  'o': "01234567",
+  'O': "01234567",
+  'b': "01",
+  'B': "01",
+}
+
 func lexNumber(l *Lexer) LexFn {
+  if set, present := radix_digits[l.lookahead(1)]; present && l.peek() == '0' {
+    l.expand()
+    l.expand()
+    l.expandDigits(set)
+    l.emit(NumberLexeme)
+    return lexCode
+  }
+
+  t := NumberLexeme
+  l.expandDigits(digits)
+
+  // a dot only starts a fraction if there's a digit after it, so that
+  // `xs[1].foo` and `1...` still lex as they should
+  if l.peek() == '.' && in(l.lookahead(1), digits) {
+    t = FloatLexeme
+    l.expand()
+    l.expandDigits(digits)
+  }
+
+  if l.peek() == 'e' || l.peek() == 'E' {
+    sign := 0
+    if l.lookahead(1) == '+' || l.lookahead(1) == '-' {
+      sign = 1
+    }
+
+    if in(l.lookahead(1 + sign), digits) {
+      t = FloatLexeme
+      l.expand()
+      if sign > 0 {
+        l.expand()
+      }
+
+      l.expandDigits(digits)
+    }
+  }
+
+  l.emit(t)
+  return lexCode
+}
+
+// expandDigits takes in a run of digits from set, which can be separated by
+// underscores, as in 1_000_000.
+func (l *Lexer) expandDigits(set string) {
   for {
-    r := l.peek()
-    if strings.IndexRune(digits, r) >= 0 {
+    if in(l.peek(), set) {
+      l.expand()
+    } else if l.peek() == '_' && in(l.lookahead(1), set) {
       l.expand()
     } else {
-      l.emit(NumberLexeme)
+      break
+    }
+  }
+}
+
+// parseInt parses a number lexeme, which can be hex, octal or binary with a
+// 0x, 0o or 0b prefix.
+func parseInt(s string) (*big.Int, bool) {
+  if len(s) > 1 && s[0] == '0' {
+    if _, present := radix_digits[rune(s[1])]; present {
+      return new(big.Int).SetString(s, 0)
+    }
+  }
+
+  return new(big.Int).SetString(strings.Replace(s, "_", "", -1), 10)
+}
+
+func lexString(l *Lexer) LexFn {
+  quote := l.peek()
+  l.expand()
+
+  for {
+    r := l.peek()
+    if r == eof || r == '\n' {
+      l.emit(ErrLexeme)
+      break
+    }
+
+    l.expand()
+
+    if r == '\\' && l.peek() != eof && l.peek() != '\n' {
+      l.expand()
+    } else if r == quote {
+      l.emit(StringLexeme)
       break
     }
   }
@@ -210,6 +596,40 @@ func lexNumber(l *Lexer) LexFn {
   return lexCode
 }
 
+var escapes = map[rune]rune{
+  'n':  '\n',
+  't':  '\t',
+  'r':  '\r',
+  '0':  0,
+  '\\': '\\',
+  '\'': '\'',
+  '"':  '"',
+}
+
+// unquote strips the quotes from a string lexeme and replaces its escapes.
+func unquote(l *Lexeme) (string, error) {
+  raw := []rune(l.value)
+  raw = raw[1:len(raw)-1]
+
+  s := make([]rune, 0, len(raw))
+  for i := 0; i < len(raw); i++ {
+    if raw[i] != '\\' {
+      s = append(s, raw[i])
+      continue
+    }
+
+    i++
+    r, present := escapes[raw[i]]
+    if !present {
+      return "", fmt.Errorf("Unknown escape `\\%c` in %s", raw[i], l.value)
+    }
+
+    s = append(s, r)
+  }
+
+  return string(s), nil
+}
+
 func lexSymbol(l *Lexer) LexFn {
   current := l.peek()
   l.expand()
@@ -221,11 +641,39 @@ func lexSymbol(l *Lexer) LexFn {
   } else if current == '!'&& next == '=' {
     l.expand()
     l.emit(InvCompareLexeme)
+  } else if current == '<' && next == '=' {
+    l.expand()
+    l.emit(LessEqualLexeme)
+  } else if current == '>' && next == '=' {
+    l.expand()
+    l.emit(GreaterEqualLexeme)
   } else if current == '-' && next == '>' {
     l.expand()
     l.emit(DefLexeme)
+  } else if current == '/' && next == '/' {
+    l.expand()
+    l.emit(IntDivideLexeme)
+  } else if current == '+' && next == '+' {
+    l.expand()
+    l.emit(IncrementLexeme)
+  } else if current == '-' && next == '-' {
+    l.expand()
+    l.emit(DecrementLexeme)
+  } else if t, present := update_map[current]; present && next == '=' {
+    l.expand()
+    l.emit(t)
+  } else if current == '.' && next == '.' {
+    l.expand()
+    if l.peek() == '.' {
+      l.expand()
+      l.emit(EllipsisLexeme)
+    } else {
+      l.emit(ErrLexeme)
+    }
   } else if t, present := symbol_map[current]; present {
     l.emit(t)
+  } else {
+    l.emit(ErrLexeme)
   }
 
   return lexCode
@@ -259,16 +707,50 @@ func lexWord(l *Lexer) LexFn {
   return lexCode
 }
 
-func (l *Lexer) Run() {
-  for state := lexStart; state != nil; {
-    state = state(l)
+// Next returns the next lexeme, running the lexer on until it has one. Once
+// the input's used up, it keeps returning EOF.
+func (l *Lexer) Next() Lexeme {
+  if l.head == len(l.queue) {
+    l.queue, l.head = l.queue[:0], 0
+
+    for len(l.queue) == 0 {
+      if l.state == nil {
+        return Lexeme{EOFLexeme, "", Span{l.source, l.start, l.start}}
+      }
+
+      l.state = l.state(l)
+    }
   }
-  close(l.stream)
+
+  lexeme := l.queue[l.head]
+  l.head++
+  return lexeme
 }
 
-func Lex(input string) *Lexer {
-  l := &Lexer {input: []rune(input), stream: make(chan Lexeme)}
+// Lex returns a lexer for the source, with tabs indenting to multiples of
+// tabWidth columns. A tabWidth below 1 means DefaultTabWidth.
+func Lex(source *Source, tabWidth int) *Lexer {
+  input := []rune(source.Text)
 
-  go l.Run()
-  return l
+  if tabWidth < 1 {
+    tabWidth = DefaultTabWidth
+  }
+
+  // bad UTF-8 comes out of the runes as replacement characters, so the text
+  // has to have them too for the offsets to line up
+  text := source.Text
+  if !utf8.ValidString(text) {
+    text = string(input)
+  }
+
+  return &Lexer{
+    source: source,
+    input: input,
+    text: text,
+    start: Position{Line: 1, Column: 1},
+    last: EOLLexeme,
+    indents: []indent{{}},
+    tabWidth: tabWidth,
+    state: lexStart,
+  }
 }
@@ -0,0 +1,133 @@
+package goon
+
+import (
+  "fmt"
+  "strings"
+  "testing"
+)
+
+// generate makes a large program out of n copies of a chunk that touches most
+// of the syntax, each with its own names.
+func generate(n int) *Source {
+  var b strings.Builder
+  for i := 0; i < n; i++ {
+    fmt.Fprintf(&b, `## Counts up from %[1]d.
+Counter%[1]d (start) ->
+  i = start # where to begin
+  forever:
+    if i %% 3 == 0 and not i > 100:
+      return i++
+    elif i ^ 2 // 7 >= 40:
+      i += 2
+    else:
+      i = i + 1
+
+items%[1]d = [1, 2.5, 'three', "four", nil, true]
+totals%[1]d = {
+  'a': items%[1]d[0] * 10,
+  'b': items%[1]d.len(),
+}
+squares%[1]d = items%[1]d.map (x) -> x
+try:
+  print Counter%[1]d(%[1]d)
+rescue e:
+  raise e
+ensure:
+  n%[1]d = 0
+
+`, i)
+  }
+
+  return &Source{"<generated>", b.String()}
+}
+
+// drain runs the lexer to the end, and returns how many lexemes it made.
+func drain(l *Lexer) int {
+  n := 0
+  for l.Next().lexeme_type != EOFLexeme {
+    n++
+  }
+
+  return n
+}
+
+func BenchmarkLex(b *testing.B) {
+  source := generate(1000)
+  b.SetBytes(int64(len(source.Text)))
+  b.ReportAllocs()
+  b.ResetTimer()
+
+  for i := 0; i < b.N; i++ {
+    drain(Lex(source, DefaultTabWidth))
+  }
+}
+
+// BenchmarkLexChannel sends every lexeme over a channel from a goroutine, the
+// way the lexer used to work, to compare against BenchmarkLex.
+func BenchmarkLexChannel(b *testing.B) {
+  source := generate(1000)
+  b.SetBytes(int64(len(source.Text)))
+  b.ReportAllocs()
+  b.ResetTimer()
+
+  for i := 0; i < b.N; i++ {
+    l := Lex(source, DefaultTabWidth)
+    stream := make(chan Lexeme)
+
+    go func() {
+      for state := lexStart; state != nil; {
+        state = state(l)
+        for _, lexeme := range l.queue[l.head:] {
+          stream <- lexeme
+        }
+        l.queue, l.head = l.queue[:0], 0
+      }
+      close(stream)
+    }()
+
+    for range stream {
+    }
+  }
+}
+
+func BenchmarkParse(b *testing.B) {
+  source := generate(1000)
+  b.SetBytes(int64(len(source.Text)))
+  b.ReportAllocs()
+  b.ResetTimer()
+
+  for i := 0; i < b.N; i++ {
+    _, err := Parse(source, DefaultTabWidth)
+    if err != nil {
+      b.Fatal(err)
+    }
+  }
+}
+
+func TestGeneratedParses(t *testing.T) {
+  source := generate(3)
+  if _, err := Parse(source, DefaultTabWidth); err != nil {
+    t.Fatal(err)
+  }
+
+  if n := drain(Lex(source, DefaultTabWidth)); n == 0 {
+    t.Fatal("no lexemes")
+  }
+}
+
+func TestTabWidth(t *testing.T) {
+  // the spaces are further in than the tab only if a tab is one column
+  source := &Source{"<test>", "if a:\n\tif b:\n  c = 1\n"}
+
+  if _, err := Parse(source, 1); err != nil {
+    t.Errorf("tab width 1: %s", err)
+  }
+
+  if _, err := Parse(source, DefaultTabWidth); err == nil {
+    t.Errorf("tab width %d: parsed", DefaultTabWidth)
+  }
+
+  if _, err := Parse(source, 0); err == nil {
+    t.Errorf("tab width 0: parsed, want the default")
+  }
+}
@@ -0,0 +1,145 @@
+package goon
+
+import "sync"
+
+type List struct {
+  items []*Value
+  lock sync.RWMutex
+}
+
+func (l *List) Len() int {
+  l.lock.RLock()
+  defer l.lock.RUnlock()
+
+  return len(l.items)
+}
+
+func (l *List) Get(i int) *Value {
+  l.lock.RLock()
+  defer l.lock.RUnlock()
+
+  return l.items[i]
+}
+
+func (l *List) Set(i int, v *Value) {
+  l.lock.Lock()
+  defer l.lock.Unlock()
+
+  l.items[i] = v
+}
+
+// Index turns an index that might count back from the end into one that
+// counts from the start, and reports whether it's in range.
+func (l *List) Index(i int) (int, bool) {
+  length := l.Len()
+  if i < 0 {
+    i += length
+  }
+
+  return i, i >= 0 && i < length
+}
+
+func (l *List) Append(v *Value) {
+  l.lock.Lock()
+  defer l.lock.Unlock()
+
+  l.items = append(l.items, v)
+}
+
+func (l *List) String() string {
+  return newPrinter().list(l)
+}
+
+type listMethod func(l *List, runtime *Runtime, args []*Value) (*Value, error)
+
+var list_methods = map[string]listMethod{
+  "append": listAppend,
+  "len":    listLen,
+  "map":    listMap,
+  "slice":  listSlice,
+}
+
+// Method returns the named method bound to this list, or nil if there's no
+// such method.
+func (l *List) Method(name string) *Value {
+  method, present := list_methods[name]
+  if !present {
+    return nil
+  }
+
+  fn := func(runtime *Runtime, args []*Value) (*Value, error) {
+    return method(l, runtime, args)
+  }
+
+  return &Value{&Builtin{name, fn}, BuiltinType}
+}
+
+// append(items...) adds the items to the end of the list, and returns it.
+func listAppend(l *List, runtime *Runtime, args []*Value) (*Value, error) {
+  for _, arg := range args {
+    l.Append(arg)
+  }
+
+  return &Value{l, ListType}, nil
+}
+
+func listLen(l *List, runtime *Runtime, args []*Value) (*Value, error) {
+  return &Value{l.Len(), IntType}, nil
+}
+
+// map(block) calls the block with each item, and returns a new list of the
+// results.
+func listMap(l *List, runtime *Runtime, args []*Value) (*Value, error) {
+  if len(args) < 1 || !args[0].Callable() {
+    return nil, NewError(TypeError, "map needs a block to call")
+  }
+
+  results := &List{}
+  for i := 0; i < l.Len(); i++ {
+    v, err := args[0].Call(runtime, []*Value{l.Get(i)})
+    if err != nil {
+      return nil, err
+    }
+
+    results.Append(v)
+  }
+
+  return &Value{results, ListType}, nil
+}
+
+// slice(start, end) returns a new list of the items from start up to end,
+// or up to the end of the list if there's no end. Either can count back
+// from the end of the list, and both have to be ints.
+func listSlice(l *List, runtime *Runtime, args []*Value) (*Value, error) {
+  l.lock.RLock()
+  defer l.lock.RUnlock()
+
+  bounds := []int{0, len(l.items)}
+  for i := 0; i < len(args) && i < 2; i++ {
+    bound, ok := args[i].Int()
+    if !ok {
+      return nil, NewError(TypeError, "slice needs int bounds, not %s", args[i].val_type)
+    }
+
+    if bound < 0 {
+      bound += len(l.items)
+    }
+
+    if bound < 0 {
+      bound = 0
+    } else if bound > len(l.items) {
+      bound = len(l.items)
+    }
+
+    bounds[i] = bound
+  }
+
+  if bounds[0] > bounds[1] {
+    bounds[0] = bounds[1]
+  }
+
+  items := make([]*Value, bounds[1] - bounds[0])
+  copy(items, l.items[bounds[0]:bounds[1]])
+
+  return &Value{&List{items: items}, ListType}, nil
+}
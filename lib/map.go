@@ -0,0 +1,187 @@
+package goon
+
+import (
+  "math"
+  "math/big"
+  "sync"
+)
+
+// A Map is an associative container that remembers the order its keys were
+// added in. Only values that compare by value - nil, strings, numbers and
+// bools - can be keys.
+type Map struct {
+  keys []*Value
+  values map[mapKey]*Value
+  lock sync.RWMutex
+}
+
+type mapKey struct {
+  val_type ValueType
+  val interface{}
+}
+
+func NewMap() *Map {
+  return &Map{values: make(map[mapKey]*Value)}
+}
+
+// keyOf returns the key that k is stored under, so that keys that are Equal
+// end up in the same place.
+func keyOf(k *Value) (mapKey, bool) {
+  switch k.val_type {
+  case FloatType:
+    // whole floats are equal to ints, so they share a key. MaxInt rounds up
+    // to 2^63 as a float, which is out of range, so the top is checked
+    // against that instead.
+    f := k.val.(float64)
+    if f != math.Trunc(f) || math.IsInf(f, 0) {
+      return mapKey{FloatType, f}, true
+    }
+
+    if f >= math.MinInt && f < 9.223372036854775808e18 {
+      return mapKey{IntType, int(f)}, true
+    }
+
+    i, _ := big.NewFloat(f).Int(nil)
+    return mapKey{IntType, i.String()}, true
+  case IntType:
+    if _, ok := k.Int(); !ok {
+      // big.Ints are pointers, so they're keyed by their digits instead
+      return mapKey{IntType, k.String()}, true
+    }
+
+    return mapKey{IntType, k.val}, true
+  case NilType, BoolType, StringType:
+    return mapKey{k.val_type, k.val}, true
+  }
+
+  return mapKey{}, false
+}
+
+func (m *Map) Len() int {
+  m.lock.RLock()
+  defer m.lock.RUnlock()
+
+  return len(m.keys)
+}
+
+func (m *Map) Get(k *Value) (*Value, bool) {
+  key, ok := keyOf(k)
+  if !ok {
+    return nil, false
+  }
+
+  m.lock.RLock()
+  defer m.lock.RUnlock()
+
+  v, present := m.values[key]
+  return v, present
+}
+
+// Set stores v under k, and reports false if k can't be used as a key.
+func (m *Map) Set(k *Value, v *Value) bool {
+  key, ok := keyOf(k)
+  if !ok {
+    return false
+  }
+
+  m.lock.Lock()
+  defer m.lock.Unlock()
+
+  if _, present := m.values[key]; !present {
+    m.keys = append(m.keys, k)
+  }
+
+  m.values[key] = v
+  return true
+}
+
+// Delete removes k, and reports whether it was there.
+func (m *Map) Delete(k *Value) bool {
+  key, ok := keyOf(k)
+  if !ok {
+    return false
+  }
+
+  m.lock.Lock()
+  defer m.lock.Unlock()
+
+  if _, present := m.values[key]; !present {
+    return false
+  }
+
+  delete(m.values, key)
+  for i, existing := range m.keys {
+    if other, _ := keyOf(existing); other == key {
+      m.keys = append(m.keys[:i], m.keys[i+1:]...)
+      break
+    }
+  }
+
+  return true
+}
+
+// Keys returns a copy of the keys, in the order they were added.
+func (m *Map) Keys() []*Value {
+  m.lock.RLock()
+  defer m.lock.RUnlock()
+
+  keys := make([]*Value, len(m.keys))
+  copy(keys, m.keys)
+
+  return keys
+}
+
+func (m *Map) String() string {
+  return newPrinter().dict(m)
+}
+
+type mapMethod func(m *Map, runtime *Runtime, args []*Value) (*Value, error)
+
+var map_methods = map[string]mapMethod{
+  "delete": mapDelete,
+  "keys":   mapKeys,
+  "len":    mapLen,
+  "values": mapValues,
+}
+
+// Method returns the named method bound to this map, or nil if there's no
+// such method.
+func (m *Map) Method(name string) *Value {
+  method, present := map_methods[name]
+  if !present {
+    return nil
+  }
+
+  fn := func(runtime *Runtime, args []*Value) (*Value, error) {
+    return method(m, runtime, args)
+  }
+
+  return &Value{&Builtin{name, fn}, BuiltinType}
+}
+
+// delete(key) removes the key, and returns whether it was there.
+func mapDelete(m *Map, runtime *Runtime, args []*Value) (*Value, error) {
+  if len(args) < 1 || !m.Delete(args[0]) {
+    return FALSE, nil
+  }
+
+  return TRUE, nil
+}
+
+func mapKeys(m *Map, runtime *Runtime, args []*Value) (*Value, error) {
+  return &Value{&List{items: m.Keys()}, ListType}, nil
+}
+
+func mapLen(m *Map, runtime *Runtime, args []*Value) (*Value, error) {
+  return &Value{m.Len(), IntType}, nil
+}
+
+func mapValues(m *Map, runtime *Runtime, args []*Value) (*Value, error) {
+  values := &List{}
+  for _, k := range m.Keys() {
+    v, _ := m.Get(k)
+    values.Append(v)
+  }
+
+  return &Value{values, ListType}, nil
+}
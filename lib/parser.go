@@ -2,31 +2,94 @@ package goon
 
 import (
   "strconv"
+  "strings"
   "errors"
   "fmt"
 )
 
 var SyntaxError = errors.New("Syntax error!")
 
+// A ParseError is a mistake in the source, found while parsing it.
+type ParseError struct {
+  Message string
+  Span Span
+}
+
+func (e *ParseError) Error() string {
+  return located(e.Span, e.Message)
+}
+
+// ParseErrors is every mistake found in a source, in the order they were
+// found.
+type ParseErrors []error
+
+func (e ParseErrors) Error() string {
+  messages := make([]string, len(e))
+  for i, err := range e {
+    messages[i] = err.Error()
+  }
+
+  return strings.Join(messages, "\n")
+}
+
 func UnexpectedError(l *Lexeme, expected string) error {
-  return errors.New(fmt.Sprintf("Unexpected %s, expected %s", l, expected))
+  return &ParseError{fmt.Sprintf("Unexpected %s, expected %s", l, expected), l.span}
 }
 
 type Parser struct {
   lexer *Lexer
   stack []ASTNode
   lexemes []*Lexeme
-  indentation int
+
+  // the array the lookahead lives in, and the lexemes yet to be handed out
+  queue []*Lexeme
+  slab []Lexeme
+
+  // the type of the last lexeme shifted
+  last LexemeType
+
+  // the errors found so far
+  errors ParseErrors
+
+  // how many loops the statement being parsed is in, inside its block
+  loops int
+
+  // the doc comment lines waiting for the next definition
+  docs []string
+}
+
+// join returns the span from the start of first to the end of last.
+func join(first Span, last Span) Span {
+  if !last.Known() {
+    return first
+  } else if !first.Known() {
+    return last
+  }
+
+  return Span{first.Source, first.Start, last.End}
 }
 
 func (p *Parser) expand() {
-  l := <-p.lexer.stream
-  if l.lexeme_type == 0 {
-    l = Lexeme{EOFLexeme, ""}
+  // lexemes are handed out from a slab at a time, rather than allocated one
+  // by one
+  if len(p.slab) == 0 {
+    p.slab = make([]Lexeme, 256)
+  }
+
+  l := &p.slab[0]
+  p.slab = p.slab[1:]
+  *l = p.lexer.Next()
+
+  // the lookahead starts over at the front of its array once it's used up,
+  // instead of creeping along it and reallocating
+  if len(p.lexemes) == 0 {
+    p.lexemes = p.queue[:0]
   }
 
-  //fmt.Printf("got lexeme: %s\n", l.String())
-  p.lexemes = append(p.lexemes, &l)
+  p.lexemes = append(p.lexemes, l)
+  if cap(p.lexemes) > cap(p.queue) {
+    p.queue = p.lexemes[:0]
+  }
 }
 
 func (p *Parser) shift() *Lexeme {
@@ -36,6 +99,7 @@ func (p *Parser) shift() *Lexeme {
 
   l := p.lexemes[0]
   p.lexemes = p.lexemes[1:]
+  p.last = l.lexeme_type
   return l
 }
 
@@ -55,8 +119,6 @@ func (p *Parser) peek(i int) LexemeType {
 
     p.expand()
   }
-
-  return ErrLexeme
 }
 
 func (p *Parser) acceptOneOf(ts ...LexemeType) *Lexeme {
@@ -73,6 +135,48 @@ func (p *Parser) acceptOneOf(ts ...LexemeType) *Lexeme {
   return nil
 }
 
+// atDefinition looks ahead for `ID (ID, ...)? ->` so that a call like
+// `DoThing()` at the start of a line isn't mistaken for a definition.
+func (p *Parser) atDefinition() bool {
+  if p.peek(0) != MethodIdentLexeme && p.peek(0) != IdentLexeme {
+    return false
+  }
+
+  return p.atBlock(1)
+}
+
+// atBlock looks ahead from the i'th lexeme for `(ID, ...)? ->`, which starts
+// a block, so that it isn't mistaken for an expression in parentheses.
+func (p *Parser) atBlock(i int) bool {
+  if p.peek(i) == DefLexeme {
+    return true
+  } else if p.peek(i) != LeftParenLexeme {
+    return false
+  }
+
+  for i++; ; i++ {
+    switch p.peek(i) {
+    case IdentLexeme, CommaLexeme:
+      continue
+    case RightParenLexeme:
+      return p.peek(i+1) == DefLexeme
+    }
+
+    return false
+  }
+}
+
+// atStatementEnd reports whether the current statement has nothing left in it
+// but postfix modifiers.
+func (p *Parser) atStatementEnd() bool {
+  switch p.peek(0) {
+  case EOLLexeme, EOFLexeme, IfLexeme, UnlessLexeme, ForeverLexeme:
+    return true
+  }
+
+  return false
+}
+
 func (p *Parser) pushNode(node ASTNode) {
   p.stack = append(p.stack, node)
 }
@@ -92,54 +196,115 @@ func (p *Parser) popTwoNodes() (ASTNode, ASTNode) {
 
 func (p *Parser) pushExpression(op Operator) {
   left, right := p.popTwoNodes()
-  node := &ExpressionNode{op, left, right}
-  p.pushNode(node)
+  span := join(left.Span(), right.Span())
+  p.pushNode(&ExpressionNode{node{span}, op, left, right})
 }
 
-func (p *Parser) pushValue(v *Value) {
-  p.pushNode(&ValueNode{v})
+func (p *Parser) pushValue(v *Value, l *Lexeme) {
+  p.pushNode(&ValueNode{node{l.span}, v})
+}
+
+// recover picks up after a statement that failed to parse, by skipping to the
+// end of its line, and past any block indented under it.
+func (p *Parser) recover() {
+  level := 0
+
+  for {
+    switch p.peek(0) {
+    case EOFLexeme:
+      return
+    case IndentLexeme:
+      level++
+    case DedentLexeme:
+      // the end of the enclosing block is left for it to find
+      if level == 0 {
+        return
+      }
+
+      level--
+      if level == 0 {
+        p.shift()
+        return
+      }
+    case EOLLexeme:
+      if level == 0 && p.peek(1) != IndentLexeme {
+        p.shift()
+        return
+      }
+    }
+
+    p.shift()
+  }
 }
 
 /*
-block = ((control EOL) | EOL)+ EOF
+block = (control (EOL | DEDENT) | DOC_COMMENT EOL? | EOL)* (DEDENT | EOF)
+
+A nested block ends at the DEDENT that closes it. A statement that fails to
+parse is left out of the block, and the error is kept so parsing can carry on
+with the next one.
 */
 func block(p *Parser) error {
   mark := len(p.stack)
 
   for {
-    indent := p.accept(IndentLexeme)
-    spaces := len(indent.value)
-    if (spaces % 2 != 0) || ((spaces / 2) > p.indentation) {
-      return errors.New(fmt.Sprintf("Unexpected indent (%d)", spaces))
-    } else if (spaces / 2) < p.indentation {
-      break
+    empty_line := p.accept(EOLLexeme)
+    if empty_line != nil {
+       continue
+    }
+
+    // doc comments are kept for the next definition
+    doc := p.accept(DocCommentLexeme)
+    if doc != nil {
+      text := strings.TrimPrefix(doc.value, "##")
+      p.docs = append(p.docs, strings.TrimPrefix(text, " "))
+      p.accept(EOLLexeme)
+      continue
     }
 
-    if p.peek(0) == EOFLexeme {
+    if p.peek(0) == EOFLexeme || p.accept(DedentLexeme) != nil {
       break
     }
 
-    empty_line := p.accept(EOLLexeme)
-    if empty_line != nil {
-       continue
+    // an indent is left for recover to skip the block it starts
+    if p.peek(0) == IndentLexeme {
+      p.errors = append(p.errors, &ParseError{"Unexpected indent", p.lexemes[0].span})
+      p.recover()
+      continue
+    }
+
+    bad := p.accept(IndentErrLexeme)
+    if bad != nil {
+      p.errors = append(p.errors, &ParseError{bad.value, bad.span})
+      p.recover()
+      continue
     }
 
+    depth := len(p.stack)
+
     err := control(p)
-    if err != nil {
-      return err
+    p.docs = nil
+
+    // a statement that ends in a nested block has already finished its line
+    if err == nil && p.last != DedentLexeme && p.accept(EOLLexeme) == nil {
+      err = UnexpectedError(p.lexemes[0], "EOL")
     }
 
-    eol := p.acceptOneOf(EOLLexeme, EOFLexeme)
-    if eol == nil {
-      return UnexpectedError(p.lexemes[0], "EOL/EOF")
-    } else if eol.lexeme_type == EOFLexeme {
-      break
+    if err != nil {
+      p.errors = append(p.errors, err)
+      p.stack = p.stack[:depth]
+      p.recover()
     }
   }
 
-  node := &BlockNode{make([]ASTNode, len(p.stack) - mark)}
+  node := &BlockNode{node{}, make([]ASTNode, len(p.stack) - mark)}
   _ = copy(node.children, p.stack[mark:])
 
+  if len(node.children) > 0 {
+    last := node.children[len(node.children)-1]
+    node.span = join(node.children[0].Span(), last.Span())
+  }
+
   p.stack = p.stack[0:mark]
   p.pushNode(node)
 
@@ -147,44 +312,49 @@ func block(p *Parser) error {
 }
 
 /*
-control = (IF | UNLESS) expression THEN block
-            (ELIF expression THEN block)*
-            (ELSE expression THEN block)?
+indented = EOL INDENT block
+*/
+func indented(p *Parser) error {
+  l := p.accept(EOLLexeme)
+  if l == nil {
+    return UnexpectedError(p.lexemes[0], "EOL")
+  }
+
+  l = p.accept(IndentLexeme)
+  if l == nil {
+    return UnexpectedError(p.lexemes[0], "an indented block")
+  }
+
+  return block(p)
+}
+
+/*
+control = (IF | UNLESS) expression body
+            (ELIF expression body)*
+            (ELSE body)?
+        / FOREVER body
+        / FOR ID IN expression body
+        / TRY body (RESCUE ID? body)? (ENSURE body)?
         / definition
 */
 func control(p *Parser) error {
   // TODO: unless won't work
-  var l *Lexeme
   var err error
 
   branch := p.acceptOneOf(IfLexeme, UnlessLexeme)
   if branch != nil {
-    branch_node := &BranchNode{make([]CondNode, 0), nil}
+    branch_node := &BranchNode{node{}, make([]CondNode, 0), nil}
 
     err = expression(p)
     if err != nil {
       return err
     }
 
-    l = p.accept(ThenLexeme)
-    if l == nil {
-      return UnexpectedError(p.lexemes[0], "':'")
-    }
-
-    l = p.accept(EOLLexeme)
-    if l == nil {
-      return UnexpectedError(p.lexemes[0], "EOL")
-    }
-
-    p.indentation++
-
-    err = block(p)
+    err = body(p)
     if err != nil {
       return err
     }
 
-    p.indentation--
-
     branch_node.AddCond(p.popTwoNodes())
 
     for {
@@ -198,53 +368,109 @@ func control(p *Parser) error {
         return err
       }
 
-      l = p.accept(ThenLexeme)
-      if l == nil {
-        return UnexpectedError(p.lexemes[0], "':'")
-      }
-
-      l = p.accept(EOLLexeme)
-      if l == nil {
-        return UnexpectedError(p.lexemes[0], "EOL")
+      err = body(p)
+      if err != nil {
+        return err
       }
 
-      p.indentation++
+      branch_node.AddCond(p.popTwoNodes())
+    }
 
-      err = block(p)
+    else_branch := p.accept(ElseLexeme)
+    if else_branch != nil {
+      err = body(p)
       if err != nil {
         return err
       }
 
-      p.indentation--
+      branch_node.default_branch = p.popNode()
+    }
 
-      branch_node.AddCond(p.popTwoNodes())
+    last := branch_node.default_branch
+    if last == nil {
+      last = branch_node.branches[len(branch_node.branches)-1].then
     }
 
-    else_branch := p.accept(ElseLexeme)
-    if else_branch != nil {
-      l = p.accept(ThenLexeme)
-      if l == nil {
-        return UnexpectedError(p.lexemes[0], "':'")
+    branch_node.span = join(branch.span, last.Span())
+    p.pushNode(branch_node)
+    return nil
+  }
+
+  loop := p.accept(ForeverLexeme)
+  if loop != nil {
+    err = loopBody(p)
+    if err != nil {
+      return err
+    }
+
+    body := p.popNode()
+    p.pushNode(&LoopNode{node{join(loop.span, body.Span())}, body})
+    return nil
+  }
+
+  for_loop := p.accept(ForLexeme)
+  if for_loop != nil {
+    ident, err := iteration(p)
+    if err != nil {
+      return err
+    }
+
+    err = loopBody(p)
+    if err != nil {
+      return err
+    }
+
+    iterable, body := p.popTwoNodes()
+    span := join(for_loop.span, body.Span())
+    p.pushNode(&ForNode{node{span}, ident, iterable, body, false})
+    return nil
+  }
+
+  try := p.accept(TryLexeme)
+  if try != nil {
+    err = body(p)
+    if err != nil {
+      return err
+    }
+
+    try_node := &TryNode{body: p.popNode()}
+
+    rescue := p.accept(RescueLexeme)
+    if rescue != nil {
+      ident := p.accept(IdentLexeme)
+      if ident != nil {
+        try_node.ident = ident.value
       }
 
-      l = p.accept(EOLLexeme)
-      if l == nil {
-        return UnexpectedError(p.lexemes[0], "EOL")
+      err = body(p)
+      if err != nil {
+        return err
       }
 
-      p.indentation++
+      try_node.rescue = p.popNode()
+    }
 
-      err = block(p)
+    ensure := p.accept(EnsureLexeme)
+    if ensure != nil {
+      err = body(p)
       if err != nil {
         return err
       }
 
-      p.indentation--
+      try_node.ensure = p.popNode()
+    }
 
-      branch_node.default_branch = p.popNode()
+    if try_node.rescue == nil && try_node.ensure == nil {
+      return UnexpectedError(p.lexemes[0], "'rescue' or 'ensure'")
     }
 
-    p.pushNode(branch_node)
+    last := try_node.ensure
+    if last == nil {
+      last = try_node.rescue
+    }
+
+    try_node.span = join(try.span, last.Span())
+    p.pushNode(try_node)
     return nil
   }
 
@@ -252,64 +478,98 @@ func control(p *Parser) error {
 }
 
 /*
-definition = METHOD_ID (LEFT_P ID (COMMA ID)+ RIGHT_P)? DEF block
+iteration = ID IN expression
+*/
+func iteration(p *Parser) (string, error) {
+  ident := p.accept(IdentLexeme)
+  if ident == nil {
+    return "", UnexpectedError(p.lexemes[0], "ID")
+  }
+
+  in := p.accept(InLexeme)
+  if in == nil {
+    return "", UnexpectedError(p.lexemes[0], "'in'")
+  }
+
+  return ident.value, expression(p)
+}
+
+// postfixFor wraps the node on top of the stack in a for loop that collects
+// its values, once the FOR has been accepted.
+func postfixFor(p *Parser) error {
+  ident, err := iteration(p)
+  if err != nil {
+    return err
+  }
+
+  body, iterable := p.popTwoNodes()
+  span := join(body.Span(), iterable.Span())
+  p.pushNode(&ForNode{node{span}, ident, iterable, body, true})
+  return nil
+}
+
+/*
+body = THEN indented
+*/
+func body(p *Parser) error {
+  l := p.accept(ThenLexeme)
+  if l == nil {
+    return UnexpectedError(p.lexemes[0], "':'")
+  }
+
+  return indented(p)
+}
+
+// loopBody parses the body of a loop, where break and continue can be used.
+func loopBody(p *Parser) error {
+  p.loops++
+  defer func() { p.loops-- }()
+
+  return body(p)
+}
+
+// blockBody parses the body of a block. Loops around the block don't count
+// inside it, since break and continue can't get out of a block.
+func blockBody(p *Parser) error {
+  loops := p.loops
+  p.loops = 0
+  defer func() { p.loops = loops }()
+
+  return indented(p)
+}
+
+/*
+definition = (ID | METHOD_ID) parameters DEF indented
            / inline_conditional
 */
 func definition(p *Parser) error {
-  var l *Lexeme
+  if !p.atDefinition() {
+    return inline_conditional(p)
+  }
 
-  method_id := p.accept(MethodIdentLexeme)
+  method_id := p.acceptOneOf(MethodIdentLexeme, IdentLexeme)
   if method_id != nil {
-    node := &DefNode{method_id.value, make([]string, 0), nil}
-
-    l = p.accept(LeftParenLexeme)
-    if l != nil {
-      var ident *Lexeme
+    node := &DefNode{node{}, method_id.value, strings.Join(p.docs, "\n"), nil, nil}
+    p.docs = nil
 
-      ident = p.accept(IdentLexeme)
-      if ident == nil {
-        return UnexpectedError(p.lexemes[0], "ID")
-      }
-      node.AddArgument(ident.value)
-
-      for {
-        l = p.acceptOneOf(CommaLexeme, RightParenLexeme)
-        if l == nil {
-          return UnexpectedError(p.lexemes[0], "')' or ','")
-        }
-
-        if l.lexeme_type == RightParenLexeme {
-          break
-        }
-
-        ident = p.accept(IdentLexeme)
-        if ident == nil {
-          return UnexpectedError(p.lexemes[0], "ID")
-        }
-        node.AddArgument(ident.value)
-      }
+    arguments, err := parameters(p)
+    if err != nil {
+      return err
     }
+    node.arguments = arguments
 
     def := p.accept(DefLexeme)
     if def == nil {
       return UnexpectedError(p.lexemes[0], "'->'")
     }
 
-    eol := p.accept(EOLLexeme)
-    if eol == nil {
-      return UnexpectedError(p.lexemes[0], "EOL")
-    }
-
-    p.indentation++
-
-    err := block(p)
+    err = blockBody(p)
     if err != nil {
       return err
     }
 
-    p.indentation--
-
     node.block = p.popNode().(*BlockNode)
+    node.span = join(method_id.span, node.block.Span())
     p.pushNode(node)
 
     return nil
@@ -319,7 +579,81 @@ func definition(p *Parser) error {
 }
 
 /*
-inline_conditional = statement ((IF | UNLESS) expr)?
+parameters = (LEFT_P (ID (COMMA ID)*)? RIGHT_P)?
+*/
+func parameters(p *Parser) ([]string, error) {
+  params := make([]string, 0)
+
+  if p.accept(LeftParenLexeme) == nil || p.accept(RightParenLexeme) != nil {
+    return params, nil
+  }
+
+  for {
+    ident := p.accept(IdentLexeme)
+    if ident == nil {
+      return nil, UnexpectedError(p.lexemes[0], "ID")
+    }
+    params = append(params, ident.value)
+
+    l := p.acceptOneOf(CommaLexeme, RightParenLexeme)
+    if l == nil {
+      return nil, UnexpectedError(p.lexemes[0], "')' or ','")
+    } else if l.lexeme_type == RightParenLexeme {
+      return params, nil
+    }
+  }
+}
+
+/*
+anonymous = parameters DEF (indented | expression)
+
+A block on one line is just an expression, and hands back its value. An
+indented block works inside brackets too, as in a call's arguments, and the
+brackets carry on after it:
+
+  Apply((x) ->
+    return x + 1
+  , 2)
+*/
+func anonymous(p *Parser) error {
+  p.peek(0)
+  first := p.lexemes[0]
+
+  arguments, err := parameters(p)
+  if err != nil {
+    return err
+  }
+
+  def := p.accept(DefLexeme)
+  if def == nil {
+    return UnexpectedError(p.lexemes[0], "'->'")
+  }
+
+  var body *BlockNode
+  if p.peek(0) == EOLLexeme {
+    err = blockBody(p)
+    if err != nil {
+      return err
+    }
+
+    body = p.popNode().(*BlockNode)
+  } else {
+    err = expression(p)
+    if err != nil {
+      return err
+    }
+
+    expr := p.popNode()
+    body = &BlockNode{node{expr.Span()}, []ASTNode{expr}}
+  }
+
+  span := join(first.span, body.Span())
+  p.pushNode(&LambdaNode{node{span}, arguments, body})
+  return nil
+}
+
+/*
+inline_conditional = statement (FOREVER | FOR iteration)? ((IF | UNLESS) expr)?
 */
 func inline_conditional(p *Parser) error {
   err := statement(p)
@@ -327,6 +661,17 @@ func inline_conditional(p *Parser) error {
     return err
   }
 
+  loop := p.acceptOneOf(ForeverLexeme, ForLexeme)
+  if loop != nil && loop.lexeme_type == ForeverLexeme {
+    body := p.popNode()
+    p.pushNode(&LoopNode{node{join(body.Span(), loop.span)}, body})
+  } else if loop != nil {
+    err := postfixFor(p)
+    if err != nil {
+      return err
+    }
+  }
+
   cond := p.acceptOneOf(IfLexeme, UnlessLexeme)
   if cond != nil {
     err := expression(p)
@@ -334,8 +679,9 @@ func inline_conditional(p *Parser) error {
       return err
     }
 
-    branch_node := &BranchNode{make([]CondNode, 0), nil}
-    branch_node.AddCond(p.popNode(), p.popNode())
+    cond, then := p.popNode(), p.popNode()
+    branch_node := &BranchNode{node{join(then.Span(), cond.Span())}, make([]CondNode, 0), nil}
+    branch_node.AddCond(cond, then)
     p.pushNode(branch_node)
   }
 
@@ -343,28 +689,37 @@ func inline_conditional(p *Parser) error {
 }
 
 /*
-statement = ID ASSIGN expression
-          / KEYWORD expression
+statement = (BREAK | CONTINUE)
+          / RETURN expression?
+          / (PRINT | RAISE) expression
+          / target ASSIGN comprehension
+          / target UPDATE expression
           / expression
 */
 func statement(p *Parser) error {
-  if p.peek(0) == IdentLexeme && p.peek(1) == AssignLexeme {
-    ident, _ := p.shift(), p.shift()
-
-    err := expression(p)
-    if err != nil {
-      return err
+  kw := p.acceptOneOf(BreakLexeme, ContinueLexeme)
+  if kw != nil {
+    // a postfix loop after it counts too
+    if p.loops == 0 && p.peek(0) != ForeverLexeme && p.peek(0) != ForLexeme {
+      return &ParseError{fmt.Sprintf("`%s` outside a loop", kw.value), kw.span}
     }
 
-    expr := p.popNode()
-    node := &AssignNode{ident.value, expr}
-    p.pushNode(node)
+    if kw.lexeme_type == BreakLexeme {
+      p.pushNode(&KeywordNode{node{kw.span}, BreakKeyword, nil})
+    } else {
+      p.pushNode(&KeywordNode{node{kw.span}, ContinueKeyword, nil})
+    }
 
     return nil
   }
 
-  kw := p.acceptOneOf(ReturnLexeme, PrintLexeme)
+  kw = p.acceptOneOf(ReturnLexeme, PrintLexeme, RaiseLexeme)
   if kw != nil {
+    if kw.lexeme_type == ReturnLexeme && p.atStatementEnd() {
+      p.pushNode(&KeywordNode{node{kw.span}, ReturnKeyword, nil})
+      return nil
+    }
+
     err := expression(p)
     if err != nil {
       return err
@@ -377,154 +732,290 @@ func statement(p *Parser) error {
       k = ReturnKeyword
     case PrintLexeme:
       k = PrintKeyword
+    case RaiseLexeme:
+      k = RaiseKeyword
     }
 
-    p.pushNode(&KeywordNode{k, n})
+    p.pushNode(&KeywordNode{node{join(kw.span, n.Span())}, k, n})
     return nil
   }
 
-  return expression(p)
-}
-
-/*
-expression = equality ((AND | OR) equality)*
-*/
-
-func expression(p *Parser) error {
-  err := equality(p)
+  err := expression(p)
   if err != nil {
     return err
   }
 
-  for {
-    l := p.acceptOneOf(AndLexeme, OrLexeme)
-    if l == nil {
-      break
+  if p.peek(0) == AssignLexeme {
+    l := p.shift()
+
+    target, ok := p.popNode().(Assignable)
+    if !ok {
+      return UnexpectedError(l, "EOL")
     }
 
-    err := equality(p)
+    err := comprehension(p)
     if err != nil {
       return err
     }
 
-    if l.lexeme_type == AndLexeme {
-      p.pushExpression(AndOp)
-    } else if l.lexeme_type == OrLexeme {
-      p.pushExpression(OrOp)
+    expr := p.popNode()
+    span := join(target.Span(), expr.Span())
+    p.pushNode(&AssignNode{node{span}, target, expr})
+  } else if op, present := update_ops[p.peek(0)]; present {
+    l := p.shift()
+
+    target, ok := p.popNode().(Assignable)
+    if !ok {
+      return UnexpectedError(l, "EOL")
+    }
+
+    err := expression(p)
+    if err != nil {
+      return err
     }
+
+    expr := p.popNode()
+    span := join(target.Span(), expr.Span())
+    p.pushNode(&UpdateNode{node{span}, target, op, expr})
   }
 
   return nil
 }
 
+// update_ops maps each of `+=`, `-=` etc to the operator it applies.
+var update_ops = map[LexemeType]Operator{
+  AddAssignLexeme:      AddOp,
+  SubtractAssignLexeme: SubtractOp,
+  MultiplyAssignLexeme: MultiplyOp,
+  DivideAssignLexeme:   DivideOp,
+}
+
 /*
-equality = sum ((EQUALS | DOES_NOT_EQUAL) sum)*
+comprehension = expression (FOR iteration)?
 */
-func equality(p *Parser) error {
-  err := sum(p)
+func comprehension(p *Parser) error {
+  err := expression(p)
   if err != nil {
     return err
   }
 
-  for {
-    l := p.acceptOneOf(CompareLexeme, InvCompareLexeme)
-    if l == nil {
-      break
-    }
-
-    err := sum(p)
-    if err != nil {
-      return err
-    }
-
-    if l.lexeme_type == CompareLexeme {
-      p.pushExpression(CompareOp)
-    } else if l.lexeme_type == InvCompareLexeme {
-      p.pushExpression(InvCompareOp)
-    }
+  if p.accept(ForLexeme) != nil {
+    return postfixFor(p)
   }
 
   return nil
 }
 
 /*
-sum = product ((PLUS | MINUS) product)*
+expression = operand (BINARY_OP operand)*
+
+Binary operators are parsed by precedence climbing, using the table below.
+Higher precedences bind tighter, and operators at the same level group to
+the left, except for `^`:
+
+  1  or
+  2  and
+  3  not (prefix)
+  4  == != in
+  5  < <= > >=
+  6  + -
+  7  * / // %
+  8  - (prefix)
+  9  ^ (right-associative)
+
+so `not a == b` is `not (a == b)`, `-2 ^ 2` is `-(2 ^ 2)` and `2 ^ 3 ^ 2` is
+`2 ^ (3 ^ 2)`.
 */
-func sum(p *Parser) error {
-  err := product(p)
+func expression(p *Parser) error {
+  return binary(p, 1)
+}
+
+type binaryOp struct {
+  operator Operator
+  precedence int
+  right_assoc bool
+}
+
+var binary_ops = map[LexemeType]binaryOp{
+  OrLexeme:           {OrOp, 1, false},
+  AndLexeme:          {AndOp, 2, false},
+  CompareLexeme:      {CompareOp, 4, false},
+  InvCompareLexeme:   {InvCompareOp, 4, false},
+  InLexeme:           {InOp, 4, false},
+  LessLexeme:         {LessOp, 5, false},
+  LessEqualLexeme:    {LessEqualOp, 5, false},
+  GreaterLexeme:      {GreaterOp, 5, false},
+  GreaterEqualLexeme: {GreaterEqualOp, 5, false},
+  AddLexeme:          {AddOp, 6, false},
+  SubtractLexeme:     {SubtractOp, 6, false},
+  MultiplyLexeme:     {MultiplyOp, 7, false},
+  DivideLexeme:       {DivideOp, 7, false},
+  IntDivideLexeme:    {IntDivideOp, 7, false},
+  ModuloLexeme:       {ModuloOp, 7, false},
+  PowerLexeme:        {PowerOp, 9, true},
+}
+
+const (
+  not_precedence = 3
+  negate_precedence = 8
+)
+
+// binary parses an expression made up of operators that bind at least as
+// tightly as min.
+func binary(p *Parser, min int) error {
+  err := operand(p)
   if err != nil {
     return err
   }
 
   for {
-    l := p.acceptOneOf(AddLexeme, SubtractLexeme)
-    if l == nil {
-      break
+    op, present := binary_ops[p.peek(0)]
+    if !present || op.precedence < min {
+      return nil
     }
 
-    err := product(p)
+    p.shift()
+
+    next := op.precedence + 1
+    if op.right_assoc {
+      next = op.precedence
+    }
+
+    err := binary(p, next)
     if err != nil {
       return err
     }
 
-    if l.lexeme_type == AddLexeme {
-      p.pushExpression(AddOp)
-    } else if l.lexeme_type == SubtractLexeme {
-      p.pushExpression(SubtractOp)
-    }
+    p.pushExpression(op.operator)
+  }
+}
+
+/*
+operand = NOT expression
+        / MINUS expression
+        / increment
+*/
+func operand(p *Parser) error {
+  l := p.acceptOneOf(NotLexeme, SubtractLexeme)
+  if l == nil {
+    return increment(p)
+  }
+
+  op, min := NotOp, not_precedence
+  if l.lexeme_type == SubtractLexeme {
+    op, min = NegateOp, negate_precedence
+  }
+
+  err := binary(p, min)
+  if err != nil {
+    return err
   }
 
+  expr := p.popNode()
+  p.pushNode(&UnaryNode{node{join(l.span, expr.Span())}, op, expr})
   return nil
 }
 
 /*
-product = value ((TIMES | DIVIDED_BY) value)*
+increment = (INCREMENT | DECREMENT) value
+          / value (INCREMENT | DECREMENT)?
 */
-func product(p *Parser) error {
+func increment(p *Parser) error {
+  prefix := p.acceptOneOf(IncrementLexeme, DecrementLexeme)
+
   err := value(p)
   if err != nil {
     return err
   }
 
-  for {
-    l := p.acceptOneOf(MultiplyLexeme, DivideLexeme)
+  l := prefix
+  if l == nil {
+    l = p.acceptOneOf(IncrementLexeme, DecrementLexeme)
     if l == nil {
-      break
+      return nil
     }
+  }
 
-    err := sum(p)
-    if err != nil {
-      return err
-    }
+  target, ok := p.popNode().(Assignable)
+  if !ok {
+    return &ParseError{fmt.Sprintf("Can't assign to the operand of %s", l), l.span}
+  }
 
-    if l.lexeme_type == MultiplyLexeme {
-      p.pushExpression(MultiplyOp)
-    } else if l.lexeme_type == DivideLexeme {
-      p.pushExpression(DivideOp)
-    }
+  delta := 1
+  if l.lexeme_type == DecrementLexeme {
+    delta = -1
   }
 
+  span := join(target.Span(), l.span)
+  if prefix != nil {
+    span = join(l.span, target.Span())
+  }
+
+  p.pushNode(&IncrementNode{node{span}, target, delta, prefix != nil})
   return nil
 }
 
 /*
-value = LEFT_P expression RIGHT_P
+value = LEFT_P comprehension RIGHT_P trailers
       / NIL
       / TRUE
       / FALSE
       / NUMBER
+      / FLOAT
+      / STRING trailers
+      / list trailers
+      / map trailers
+      / NEW id
+      / ELLIPSIS value
       / id
 */
 func value(p *Parser) error {
-  l := p.acceptOneOf(NilLexeme, TrueLexeme, FalseLexeme, NumberLexeme, LeftParenLexeme)
+  if p.atBlock(0) {
+    return anonymous(p)
+  } else if p.peek(0) == LeftBracketLexeme {
+    err := list(p)
+    if err != nil {
+      return err
+    }
+
+    return trailers(p)
+  } else if p.peek(0) == LeftBraceLexeme {
+    err := dict(p)
+    if err != nil {
+      return err
+    }
+
+    return trailers(p)
+  }
+
+  l := p.acceptOneOf(
+    NilLexeme, TrueLexeme, FalseLexeme, NumberLexeme, FloatLexeme, StringLexeme,
+    LeftParenLexeme, NewLexeme, EllipsisLexeme,
+  )
 
   if l == nil {
     return id(p)
   }
 
   switch l.lexeme_type {
+  case EllipsisLexeme:
+    err := value(p)
+    if err != nil {
+      return err
+    }
+
+    expr := p.popNode()
+    p.pushNode(&AwaitNode{node{join(l.span, expr.Span())}, expr})
+  case NewLexeme:
+    err := id(p)
+    if err != nil {
+      return err
+    }
+
+    target := p.popNode()
+    p.pushNode(&NewNode{node{join(l.span, target.Span())}, target})
   case LeftParenLexeme:
-    err := expression(p)
+    err := comprehension(p)
     if err != nil {
       return err
     }
@@ -533,72 +1024,281 @@ func value(p *Parser) error {
     if close_paren == nil {
       return UnexpectedError(p.lexemes[0], "')'")
     }
+
+    return trailers(p)
   case NilLexeme:
-    p.pushValue(NIL)
+    p.pushValue(NIL, l)
   case TrueLexeme:
-    p.pushValue(TRUE)
+    p.pushValue(TRUE, l)
   case FalseLexeme:
-    p.pushValue(FALSE)
+    p.pushValue(FALSE, l)
   case NumberLexeme:
-    i, _ := strconv.Atoi(l.value)
-    p.pushValue(&Value{i, IntType})
-  case IdentLexeme:
+    i, ok := parseInt(l.value)
+    if !ok {
+      return &ParseError{fmt.Sprintf("Invalid number: %s", l.value), l.span}
+    }
 
+    p.pushValue(newInt(i), l)
+  case FloatLexeme:
+    f, err := strconv.ParseFloat(strings.Replace(l.value, "_", "", -1), 64)
+    if err != nil {
+      return &ParseError{fmt.Sprintf("Float out of range: %s", l.value), l.span}
+    }
+
+    p.pushValue(&Value{f, FloatType}, l)
+  case StringLexeme:
+    str, err := unquote(l)
+    if err != nil {
+      return &ParseError{err.Error(), l.span}
+    }
+
+    p.pushValue(&Value{str, StringType}, l)
+    return trailers(p)
   }
 
   return nil
 }
 
 /*
-id = ID (LEFT_P (expression (COMMA expression)+)? RIGHT_P)?
+list = LEFT_B expression FOR iteration RIGHT_B
+     / LEFT_B (expression (COMMA expression)* COMMA?)? RIGHT_B
+
+A comprehension in brackets is the list it collects, not a list holding it.
+*/
+func list(p *Parser) error {
+  var l *Lexeme
+
+  open := p.accept(LeftBracketLexeme)
+  if open == nil {
+    return UnexpectedError(p.lexemes[0], "'['")
+  }
+
+  node := &ListNode{node{}, make([]ASTNode, 0)}
+  for {
+    l = p.accept(RightBracketLexeme)
+    if l != nil {
+      break
+    }
+
+    err := expression(p)
+    if err != nil {
+      return err
+    }
+
+    if len(node.items) == 0 && p.accept(ForLexeme) != nil {
+      return listComprehension(p, open)
+    }
+
+    node.AddItem(p.popNode())
+
+    l = p.acceptOneOf(CommaLexeme, RightBracketLexeme)
+    if l == nil {
+      return UnexpectedError(p.lexemes[0], "']' or ','")
+    } else if l.lexeme_type == RightBracketLexeme {
+      break
+    }
+  }
+
+  node.span = join(open.span, l.span)
+  p.pushNode(node)
+  return nil
+}
+
+// listComprehension finishes off a list that's a comprehension, once the FOR
+// has been accepted.
+func listComprehension(p *Parser, open *Lexeme) error {
+  err := postfixFor(p)
+  if err != nil {
+    return err
+  }
+
+  close_bracket := p.accept(RightBracketLexeme)
+  if close_bracket == nil {
+    return UnexpectedError(p.lexemes[0], "']'")
+  }
+
+  loop := p.popNode().(*ForNode)
+  loop.span = join(open.span, close_bracket.span)
+  p.pushNode(loop)
+  return nil
+}
+
+/*
+map = LEFT_BR (expression THEN expression
+                (COMMA expression THEN expression)* COMMA?)? RIGHT_BR
+*/
+func dict(p *Parser) error {
+  var l *Lexeme
+
+  open := p.accept(LeftBraceLexeme)
+  if open == nil {
+    return UnexpectedError(p.lexemes[0], "'{'")
+  }
+
+  node := &MapNode{node{}, make([]ASTNode, 0), make([]ASTNode, 0)}
+  for {
+    l = p.accept(RightBraceLexeme)
+    if l != nil {
+      break
+    }
+
+    err := expression(p)
+    if err != nil {
+      return err
+    }
+
+    l = p.accept(ThenLexeme)
+    if l == nil {
+      return UnexpectedError(p.lexemes[0], "':'")
+    }
+
+    err = expression(p)
+    if err != nil {
+      return err
+    }
+
+    node.AddPair(p.popTwoNodes())
+
+    l = p.acceptOneOf(CommaLexeme, RightBraceLexeme)
+    if l == nil {
+      return UnexpectedError(p.lexemes[0], "'}' or ','")
+    } else if l.lexeme_type == RightBraceLexeme {
+      break
+    }
+  }
+
+  node.span = join(open.span, l.span)
+  p.pushNode(node)
+  return nil
+}
+
+/*
+id = (ID | METHOD_ID) trailers
 */
 func id(p *Parser) error {
-  ident := p.accept(IdentLexeme)
+  ident := p.acceptOneOf(IdentLexeme, MethodIdentLexeme)
   if ident == nil {
     return UnexpectedError(p.lexemes[0], "ID")
   }
 
-  var l *Lexeme
+  p.pushNode(&IdentNode{node{ident.span}, ident.value})
+  return trailers(p)
+}
 
-  l = p.accept(LeftParenLexeme)
-  if l != nil {
+/*
+trailers = (call | DOT (ID | METHOD_ID) | LEFT_B expression RIGHT_B)*
+             (call ELLIPSIS | anonymous)?
 
-    node := &CallNode{ident.value, make([]ASTNode, 0)}
-    first := true
-    for {
-      l = p.accept(RightParenLexeme)
-      if l != nil {
+A block straight after a name is passed to it, so `xs.map (x) -> x * 2` calls
+map with the block.
+*/
+func trailers(p *Parser) error {
+  for {
+    switch p.stack[len(p.stack)-1].(type) {
+    case *IdentNode, *MemberNode:
+      if !p.atBlock(0) {
         break
       }
 
-      if first {
-        first = false
-      } else {
-        l = p.accept(CommaLexeme)
-        if l == nil && !first {
-          return UnexpectedError(p.lexemes[0], "')' or ','")
-        }
+      err := anonymous(p)
+      if err != nil {
+        return err
       }
 
+      callee, block := p.popTwoNodes()
+      span := join(callee.Span(), block.Span())
+      p.pushNode(&CallNode{node{span}, callee, []ASTNode{block}})
+      return nil
+    }
+
+    if p.peek(0) == LeftParenLexeme {
+      err := call(p)
+      if err != nil {
+        return err
+      }
+    } else if p.accept(DotLexeme) != nil {
+      member := p.acceptOneOf(IdentLexeme, MethodIdentLexeme)
+      if member == nil {
+        return UnexpectedError(p.lexemes[0], "ID")
+      }
+
+      target := p.popNode()
+      span := join(target.Span(), member.span)
+      p.pushNode(&MemberNode{node{span}, target, member.value})
+    } else if p.accept(LeftBracketLexeme) != nil {
       err := expression(p)
       if err != nil {
         return err
       }
 
-      node.AddArgument(p.popNode())
+      close_bracket := p.accept(RightBracketLexeme)
+      if close_bracket == nil {
+        return UnexpectedError(p.lexemes[0], "']'")
+      }
+
+      target, index := p.popTwoNodes()
+      span := join(target.Span(), close_bracket.span)
+      p.pushNode(&IndexNode{node{span}, target, index})
+    } else {
+      break
     }
+  }
 
-    p.pushNode(node)
-  } else {
-    p.pushNode(&IdentNode{ident.value})
+  // a trailing ellipsis sends the call to the background
+  if call, ok := p.stack[len(p.stack)-1].(*CallNode); ok {
+    if ellipsis := p.accept(EllipsisLexeme); ellipsis != nil {
+      p.popNode()
+      p.pushNode(&SpawnNode{node{join(call.Span(), ellipsis.span)}, call})
+    }
   }
 
   return nil
 }
 
-func Parse(input string) (ASTNode, error) {
-  lexer := Lex(input)
-  parser := &Parser{lexer, make([]ASTNode, 0, 1024), make([]*Lexeme, 0), 0}
+/*
+call = LEFT_P (comprehension (COMMA comprehension)* COMMA?)? RIGHT_P
+*/
+func call(p *Parser) error {
+  var l *Lexeme
+
+  l = p.accept(LeftParenLexeme)
+  if l == nil {
+    return UnexpectedError(p.lexemes[0], "'('")
+  }
+
+  node := &CallNode{node{}, p.popNode(), make([]ASTNode, 0)}
+  for {
+    l = p.accept(RightParenLexeme)
+    if l != nil {
+      break
+    }
+
+    err := comprehension(p)
+    if err != nil {
+      return err
+    }
+
+    node.AddArgument(p.popNode())
+
+    l = p.acceptOneOf(CommaLexeme, RightParenLexeme)
+    if l == nil {
+      return UnexpectedError(p.lexemes[0], "')' or ','")
+    } else if l.lexeme_type == RightParenLexeme {
+      break
+    }
+  }
+
+  node.span = join(node.callee.Span(), l.span)
+  p.pushNode(node)
+  return nil
+}
+
+// Parse parses the source into a tree, with tabs indenting to multiples of
+// tabWidth columns. If there are mistakes in it, they're returned as
+// ParseErrors, along with a tree of the parts that did parse.
+func Parse(source *Source, tabWidth int) (ASTNode, error) {
+  lexer := Lex(source, tabWidth)
+  parser := &Parser{lexer: lexer, stack: make([]ASTNode, 0, 1024), queue: make([]*Lexeme, 0, 16)}
 
   err := block(parser)
   if err != nil {
@@ -608,9 +1308,13 @@ func Parse(input string) (ASTNode, error) {
   if len(parser.stack) != 1 {
     return nil, SyntaxError
   } else if parser.peek(0) != EOFLexeme {
-    return nil, UnexpectedError(parser.lexemes[0], "EOF")
+    parser.errors = append(parser.errors, UnexpectedError(parser.lexemes[0], "EOF"))
   }
 
   root := parser.popNode()
+  if len(parser.errors) > 0 {
+    return root, parser.errors
+  }
+
   return root, nil
 }
@@ -0,0 +1,119 @@
+package goon
+
+import (
+  "fmt"
+  "testing"
+)
+
+// shape writes an expression tree out with every operator in parentheses, so
+// it shows how the parser grouped things.
+func shape(n ASTNode) string {
+  switch n := n.(type) {
+  case *ExpressionNode:
+    return fmt.Sprintf("(%s %s %s)", shape(n.left), n.operator, shape(n.right))
+  case *UnaryNode:
+    return fmt.Sprintf("(%s %s)", n.operator, shape(n.expr))
+  case *ValueNode:
+    return n.value.String()
+  case *IdentNode:
+    return n.ident
+  }
+
+  return fmt.Sprintf("<%T>", n)
+}
+
+func TestPrecedence(t *testing.T) {
+  tests := []struct {
+    source string
+    want string
+  }{
+    {"2 * 3 + 4", "((2 * 3) + 4)"},
+    {"2 + 3 * 4", "(2 + (3 * 4))"},
+    {"10 - 2 - 3", "((10 - 2) - 3)"},
+    {"2 ^ 3 ^ 2", "(2 ^ (3 ^ 2))"},
+    {"-2 ^ 2", "(neg (2 ^ 2))"},
+    {"not a == b", "(not (a == b))"},
+    {"a and b or c", "((a and b) or c)"},
+    {"a or b and c", "(a or (b and c))"},
+    {"a < b == c < d", "((a < b) == (c < d))"},
+    {"7 // 2 * 3", "((7 // 2) * 3)"},
+    {"1 + 7 // 2", "(1 + (7 // 2))"},
+    {"7 % 3 * 2", "((7 % 3) * 2)"},
+    {"1 + 7 % 3", "(1 + (7 % 3))"},
+    {"-7 % 3", "((neg 7) % 3)"},
+    {"(2 + 3) * 4", "((2 + 3) * 4)"},
+  }
+
+  for _, test := range tests {
+    root, err := Parse(&Source{"<test>", test.source}, DefaultTabWidth)
+    if err != nil {
+      t.Errorf("%s: %s", test.source, err)
+      continue
+    }
+
+    children := root.(*BlockNode).children
+    if len(children) != 1 {
+      t.Errorf("%s: got %d statements, want 1", test.source, len(children))
+      continue
+    }
+
+    if got := shape(children[0]); got != test.want {
+      t.Errorf("%s: got %s, want %s", test.source, got, test.want)
+    }
+  }
+}
+
+func TestBreakOutsideLoop(t *testing.T) {
+  tests := []struct {
+    source string
+    ok bool
+  }{
+    {"break\n", false},
+    {"continue\n", false},
+    {"F ->\n  break\n", false},
+    {"forever:\n  F ->\n    break\n", false},
+    {"forever:\n  break\n", true},
+    {"for x in [1]:\n  if x:\n    continue\n", true},
+    {"break forever\n", true},
+    {"F ->\n  forever:\n    break\n", true},
+  }
+
+  for _, test := range tests {
+    _, err := Parse(&Source{"<test>", test.source}, DefaultTabWidth)
+    if ok := err == nil; ok != test.ok {
+      t.Errorf("%s: got error %v", test.source, err)
+    }
+  }
+}
+
+// A source with several mistakes has them all reported, and the statements
+// that did parse still come back as a tree.
+func TestParseErrors(t *testing.T) {
+  source := &Source{"<test>", "x = 1 +\ny = )\nif x\n  z = 2\nprint 3 3\nw = 4\n"}
+  root, err := Parse(source, DefaultTabWidth)
+
+  errs, ok := err.(ParseErrors)
+  if !ok {
+    t.Fatalf("got %v, want ParseErrors", err)
+  }
+
+  want := []Position{{Line: 1, Column: 8}, {Line: 2, Column: 5}, {Line: 3, Column: 5}, {Line: 5, Column: 9}}
+  if len(errs) != len(want) {
+    t.Fatalf("got %d errors, want %d\n%s", len(errs), len(want), errs)
+  }
+
+  for i, err := range errs {
+    at := err.(*ParseError).Span.Start
+    if at.Line != want[i].Line || at.Column != want[i].Column {
+      t.Errorf("error %d at %d:%d, want %d:%d", i, at.Line, at.Column, want[i].Line, want[i].Column)
+    }
+  }
+
+  if root == nil {
+    t.Fatal("got no tree")
+  } else if block, ok := root.(*BlockNode); !ok {
+    t.Errorf("got a %T, want a block", root)
+  } else if len(block.children) != 1 {
+    t.Errorf("got %d statements, want only the last", len(block.children))
+  }
+}
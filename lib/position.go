@@ -0,0 +1,106 @@
+package goon
+
+import (
+  "fmt"
+  "strings"
+)
+
+// A Source is a named piece of program text, like a file.
+type Source struct {
+  Name string
+  Text string
+}
+
+// A Position is a place in a source. Lines and columns count from 1, and
+// columns and offsets are in runes.
+type Position struct {
+  Line int
+  Column int
+  Offset int
+}
+
+// advance returns the position just past the runes, which start at p.
+func (p Position) advance(runes []rune) Position {
+  for _, r := range runes {
+    if r == '\n' {
+      p.Line++
+      p.Column = 1
+    } else {
+      p.Column++
+    }
+
+    p.Offset++
+  }
+
+  return p
+}
+
+// A Span is the stretch of source a lexeme or node came from. It's empty
+// where the source isn't known.
+type Span struct {
+  Source *Source
+  Start Position
+  End Position
+}
+
+func (s Span) Known() bool {
+  return s.Source != nil
+}
+
+// String gives the start of the span as `file:line:col`.
+func (s Span) String() string {
+  if !s.Known() {
+    return "<unknown>"
+  }
+
+  return fmt.Sprintf("%s:%d:%d", s.Source.Name, s.Start.Line, s.Start.Column)
+}
+
+// Excerpt returns the line the span starts on, with the span underlined. A
+// span that runs on past the line is underlined to the end of it.
+func (s Span) Excerpt() string {
+  if !s.Known() {
+    return ""
+  }
+
+  lines := strings.Split(s.Source.Text, "\n")
+  if s.Start.Line > len(lines) {
+    return ""
+  }
+
+  line := []rune(strings.TrimRight(lines[s.Start.Line-1], "\r"))
+  start := s.Start.Column - 1
+  if start > len(line) {
+    start = len(line)
+  }
+
+  end := len(line)
+  if s.End.Line == s.Start.Line && s.End.Column - 1 < end {
+    end = s.End.Column - 1
+  }
+
+  // keep any tabs in the indent, so the caret lines up under them
+  indent := make([]rune, start)
+  for i := range indent {
+    indent[i] = ' '
+    if line[i] == '\t' {
+      indent[i] = '\t'
+    }
+  }
+
+  underline := "^"
+  if end - start > 1 {
+    underline += strings.Repeat("~", end - start - 1)
+  }
+
+  return fmt.Sprintf("  %s\n  %s%s", string(line), string(indent), underline)
+}
+
+// located puts a message together with where it happened, if that's known.
+func located(s Span, message string) string {
+  if !s.Known() {
+    return message
+  }
+
+  return fmt.Sprintf("%s: %s\n%s", s, message, s.Excerpt())
+}
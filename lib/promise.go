@@ -0,0 +1,73 @@
+package goon
+
+// A Promise is the eventual result of a block called in the background with
+// `...`.
+type Promise struct {
+  done chan struct{}
+  value *Value
+  err error
+}
+
+// Spawn calls the callee on its own goroutine. If the call fails, the error is
+// kept and handed to whoever waits on the promise.
+func Spawn(runtime *Runtime, callee *Value, args []*Value) *Promise {
+  p := &Promise{done: make(chan struct{})}
+  background := runtime.spawn()
+
+  go func() {
+    defer close(p.done)
+    p.value, p.err = callee.Call(background, args)
+  }()
+
+  return p
+}
+
+// Wait blocks until the call has finished.
+func (p *Promise) Wait() (*Value, error) {
+  <-p.done
+
+  // every waiter gets its own copy of the error, since the stack trace grows
+  // as it's handed back
+  if e, ok := p.err.(*RuntimeError); ok {
+    copied := *e
+    copied.Stack = append([]Frame(nil), e.Stack...)
+    return nil, &copied
+  }
+
+  return p.value, p.err
+}
+
+// Await waits on a promise, or on every promise in a list, and returns
+// anything else as it is.
+func Await(v *Value) (*Value, error) {
+  return await(v, make(map[*List]*List))
+}
+
+// await keeps the lists it's already started on, so a list that holds itself
+// comes back as one that holds itself, rather than being waited on forever.
+func await(v *Value, awaited map[*List]*List) (*Value, error) {
+  switch v.val_type {
+  case PromiseType:
+    return v.val.(*Promise).Wait()
+  case ListType:
+    list := v.val.(*List)
+    if results, present := awaited[list]; present {
+      return &Value{results, ListType}, nil
+    }
+
+    results := &List{}
+    awaited[list] = results
+    for i := 0; i < list.Len(); i++ {
+      result, err := await(list.Get(i), awaited)
+      if err != nil {
+        return nil, err
+      }
+
+      results.Append(result)
+    }
+
+    return &Value{results, ListType}, nil
+  }
+
+  return v, nil
+}
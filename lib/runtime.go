@@ -1,25 +1,117 @@
 package goon
 
-import "fmt"
+// Signals are raised by return, break and continue, and unwind the stack until
+// something handles them: the enclosing call for a return, or the enclosing
+// loop for the others.
+type Signal int
+const (
+  NoSignal Signal = iota
+  ReturnSignal
+  BreakSignal
+  ContinueSignal
+)
 
 type Runtime struct {
-  ns map[string]*Value
+  // how many columns a tab indents to in the code it's given to run
+  TabWidth int
+
+  globals *Scope
+  scope *Scope
+
+  signal Signal
+  returning *Value
+
+  // how many blocks deep the runtime is running
+  depth int
+
+  // the path of child indices down to the return a block suspended at. It's
+  // built innermost-first while unwinding, and consumed from the end when the
+  // block is resumed.
+  resume []resumePoint
 }
 
+type resumePoint struct {
+  index int
+
+  // the iterator of a for loop, which has to pick up where it left off
+  iter Iterator
+}
+
+// MaxDepth is how deep calls can nest before a RecursionError is raised,
+// rather than letting the Go stack overflow and take the program with it.
+const MaxDepth = 1000
+
 func New() *Runtime {
-  runtime := &Runtime{}
-  runtime.ns = make(map[string]*Value, 1024)
+  runtime := &Runtime{TabWidth: DefaultTabWidth}
+  runtime.globals = NewScope(nil)
+  defineBuiltins(runtime.globals)
+  runtime.scope = runtime.globals
 
   return runtime
 }
 
-func (r *Runtime) Interperet(input string) *Value {
-  root, err := Parse(input)
+// Interperet runs the input, and returns the value of its last statement. If
+// it can't be parsed or fails while running, the error is returned instead.
+// The name is used to say where errors happened, and is usually a file name.
+func (r *Runtime) Interperet(name string, input string) (*Value, error) {
+  root, err := Parse(&Source{name, input}, r.TabWidth)
   if err != nil {
-    fmt.Printf("Error! %s\n", err)
-    return nil
+    return nil, err
   }
 
   root.Describe(0)
-  return root.Evaluate(r)
+  value, err := root.Evaluate(r)
+
+  r.scope = r.globals
+  r.signal, r.returning = NoSignal, nil
+  r.resume = nil
+
+  if err != nil {
+    return nil, unwind(err, "<main>")
+  }
+
+  return value, nil
+}
+
+func (r *Runtime) unwinding() bool {
+  return r.signal != NoSignal
+}
+
+// spawn returns a runtime for running a call in the background. It shares
+// this runtime's scopes, but unwinds and resumes on its own.
+func (r *Runtime) spawn() *Runtime {
+  return &Runtime{TabWidth: r.TabWidth, globals: r.globals, scope: r.scope}
+}
+
+// copyResume copies a resume path along with its iterators, so running on from
+// the copy leaves the original as it was.
+func copyResume(resume []resumePoint) []resumePoint {
+  copied := make([]resumePoint, len(resume))
+  for i, point := range resume {
+    copied[i] = resumePoint{point.index, cloneIterator(point.iter)}
+  }
+
+  return copied
+}
+
+// popResume takes the next step of the resume path. If that was the last
+// step, the node that suspended was the return statement itself.
+func (r *Runtime) popResume() (resumePoint, bool) {
+  end := len(r.resume)-1
+  point := r.resume[end]
+  r.resume = r.resume[:end]
+
+  return point, len(r.resume) == 0
+}
+
+func (r *Runtime) resuming() bool {
+  return len(r.resume) > 0
+}
+
+func (r *Runtime) suspend(i int) {
+  r.resume = append(r.resume, resumePoint{index: i})
+}
+
+func (r *Runtime) suspendIterator(iter Iterator) {
+  r.resume = append(r.resume, resumePoint{iter: iter})
 }
\ No newline at end of file
@@ -0,0 +1,557 @@
+package goon
+
+import "testing"
+
+// run interperets the source, and returns what its last statement printed as.
+func run(t *testing.T, source string) string {
+  t.Helper()
+
+  v, err := New().Interperet("<test>", source)
+  if err != nil {
+    t.Fatalf("%s\n%s", source, err)
+  }
+
+  return v.String()
+}
+
+func TestEnsureRunsOnReturn(t *testing.T) {
+  tests := []struct {
+    source string
+    want string
+  }{
+    {
+      "log = []\n" +
+      "Read ->\n" +
+      "  try:\n" +
+      "    return 1\n" +
+      "  ensure:\n" +
+      "    log.append('closing')\n" +
+      "log.append(Read())\n",
+      `["closing", 1]`,
+    },
+    {
+      "log = []\n" +
+      "Read ->\n" +
+      "  try:\n" +
+      "    raise 'oops'\n" +
+      "  rescue e:\n" +
+      "    return e.message\n" +
+      "  ensure:\n" +
+      "    log.append('closing')\n" +
+      "log.append(Read())\n",
+      `["closing", "oops"]`,
+    },
+    {
+      // the block is still suspended inside the try, and carries on there
+      "log = []\n" +
+      "Gen ->\n" +
+      "  try:\n" +
+      "    return 1\n" +
+      "    return 2\n" +
+      "  ensure:\n" +
+      "    log.append('ensure')\n" +
+      "log.append(Gen())\n" +
+      "log.append(Gen())\n",
+      `["ensure", 1, "ensure", 2]`,
+    },
+    {
+      // returning from the ensure takes the place of the body's return
+      "Read ->\n" +
+      "  try:\n" +
+      "    return 1\n" +
+      "  ensure:\n" +
+      "    return 2\n" +
+      "Read()\n",
+      `2`,
+    },
+  }
+
+  for _, test := range tests {
+    if got := run(t, test.source); got != test.want {
+      t.Errorf("%s\ngot %s, want %s", test.source, got, test.want)
+    }
+  }
+}
+
+// fails interperets the source, and returns the error it fails with.
+func fails(t *testing.T, source string) *RuntimeError {
+  t.Helper()
+
+  _, err := New().Interperet("<test>", source)
+  e, ok := err.(*RuntimeError)
+  if !ok {
+    t.Fatalf("%s\ngot %v, want a runtime error", source, err)
+  }
+
+  return e
+}
+
+func TestBadMapKeys(t *testing.T) {
+  for _, source := range []string{"{[1]: 2}\n", "m = {}\nm[[1]] = 3\n", "m = {}\nm[[1]]\n", "m = {}\nm[[1]] += 1\n"} {
+    e := fails(t, source)
+    if e.Kind != TypeError || e.Message != "Can't use list as a map key" {
+      t.Errorf("%s\ngot %s", source, e)
+    }
+  }
+}
+
+// Ints and floats are compared exactly, not by rounding the int to a float.
+func TestMixedComparison(t *testing.T) {
+  tests := []struct {
+    source string
+    want string
+  }{
+    {"1 == 1.0\n", "true"},
+    {"2 ^ 53 + 1 == 9007199254740992.0\n", "false"},
+    {"2 ^ 53 + 1 > 9007199254740992.0\n", "true"},
+    {"9223372036854775807 == 9223372036854775807.0\n", "false"},
+    {"9223372036854775807 < 9223372036854775808.0\n", "true"},
+    {"2 ^ 64 == 18446744073709551616.0\n", "true"},
+    {"inf = 1e300 * 1e300\n[2 ^ 70 < inf, -inf < -(2 ^ 70)]\n", "[true, true]"},
+    {"nan = 1e300 * 1e300 * 0\n[nan == 1, nan < 1, 1 < nan]\n", "[false, false, false]"},
+  }
+
+  for _, test := range tests {
+    if got := run(t, test.source); got != test.want {
+      t.Errorf("%s\ngot %s, want %s", test.source, got, test.want)
+    }
+  }
+}
+
+// Keys that are == to each other have to find the same item.
+func TestMapKeysFollowEquals(t *testing.T) {
+  tests := []struct {
+    source string
+    want string
+  }{
+    {"{2.0: 1}[2]\n", "1"},
+    {"{2: 1}[2.5]\n", "nil"},
+    {"{-9223372036854775808.0: 1}[-9223372036854775808]\n", "1"},
+    {"{9223372036854775808.0: 1}[-9223372036854775808]\n", "nil"},
+    {"{9223372036854775808.0: 1}[9223372036854775808]\n", "1"},
+    {"{2 ^ 70: 1}[1180591620717411303424.0]\n", "1"},
+    {"{1180591620717411303424.0: 1}[2 ^ 70]\n", "1"},
+  }
+
+  for _, test := range tests {
+    if got := run(t, test.source); got != test.want {
+      t.Errorf("%s\ngot %s, want %s", test.source, got, test.want)
+    }
+  }
+}
+
+func TestListComprehension(t *testing.T) {
+  tests := []struct {
+    source string
+    want string
+  }{
+    {"[x + 1 for x in [1, 2]]\n", "[2, 3]"},
+    {"[x for x in []]\n", "[]"},
+    {"[(x for x in [1]), 2]\n", "[[1], 2]"},
+    {"[x for x in [1, 2]][1]\n", "2"},
+  }
+
+  for _, test := range tests {
+    if got := run(t, test.source); got != test.want {
+      t.Errorf("%s\ngot %s, want %s", test.source, got, test.want)
+    }
+  }
+}
+
+func TestUnknownMember(t *testing.T) {
+  tests := []struct {
+    source string
+    want string
+  }{
+    {"Car ->\n  speed = 0\n  return\nc = new Car()\nc.sped\n", "No member `sped` on block"},
+    {"[1].nope\n", "No member `nope` on list"},
+  }
+
+  for _, test := range tests {
+    e := fails(t, test.source)
+    if e.Kind != NameError || e.Message != test.want {
+      t.Errorf("%s\ngot %s, want %s", test.source, e, test.want)
+    }
+  }
+}
+
+func TestBlocksInBrackets(t *testing.T) {
+  tests := []struct {
+    source string
+    want string
+  }{
+    {
+      "Apply(g, v) ->\n" +
+      "  return g(v)\n" +
+      "Apply((x) ->\n" +
+      "  return x + 1\n" +
+      ", 2)\n",
+      "3",
+    },
+    {
+      "[1, 2].map((x) ->\n" +
+      "  if x > 1:\n" +
+      "    return 'big'\n" +
+      "  return 'small'\n" +
+      ")\n",
+      `["small", "big"]`,
+    },
+    {
+      "if true:\n" +
+      "  m = {'f': ->\n" +
+      "      return 3\n" +
+      "    }\n" +
+      "m['f']()\n",
+      "3",
+    },
+  }
+
+  for _, test := range tests {
+    if got := run(t, test.source); got != test.want {
+      t.Errorf("%s\ngot %s, want %s", test.source, got, test.want)
+    }
+  }
+}
+
+func TestNewForksTheInstanceThatRan(t *testing.T) {
+  source := "Car (speed) ->\n" +
+    "  if speed < 2:\n" +
+    "    child = new Car(speed + 1)\n" +
+    "  return\n" +
+    "c = new Car(0)\n" +
+    "[c.speed, c.child.speed, c.child.child.speed]\n"
+
+  if got, want := run(t, source), "[0, 1, 2]"; got != want {
+    t.Errorf("got %s, want %s", got, want)
+  }
+}
+
+// Ints that don't fit in a Go int become big ones, and come back again once
+// they do.
+func TestIntOverflow(t *testing.T) {
+  tests := []struct {
+    source string
+    want string
+  }{
+    {"9223372036854775807 + 1\n", "9223372036854775808"},
+    {"-9223372036854775808 - 1\n", "-9223372036854775809"},
+    {"9223372036854775807 * 2\n", "18446744073709551614"},
+    {"-(-9223372036854775808)\n", "9223372036854775808"},
+    {"-9223372036854775808 / -1\n", "9223372036854775808"},
+    {"-9223372036854775808 // -1\n", "9223372036854775808"},
+    {"-9223372036854775808 % -1\n", "0"},
+    {"2 ^ 64\n", "18446744073709551616"},
+    {"(2 ^ 64) // -3\n", "-6148914691236517206"},
+    {"(2 ^ 64) % -3\n", "-2"},
+    {"(9223372036854775807 + 1) - 1\n", "9223372036854775807"},
+    {"x = 9223372036854775807\nx += 1\nx\n", "9223372036854775808"},
+    {"x = 9223372036854775807\nx++\nx\n", "9223372036854775808"},
+    {"-7 // 2\n", "-4"},
+    {"-7 % 2\n", "1"},
+    {"7 % -2\n", "-1"},
+  }
+
+  for _, test := range tests {
+    if got := run(t, test.source); got != test.want {
+      t.Errorf("%s\ngot %s, want %s", test.source, got, test.want)
+    }
+  }
+}
+
+// Big and small ints compare by value, whichever way they're held.
+func TestIntEquality(t *testing.T) {
+  tests := []struct {
+    source string
+    want string
+  }{
+    {"(9223372036854775807 + 1) - 1 == 9223372036854775807\n", "true"},
+    {"2 ^ 63 - 1 == 9223372036854775807\n", "true"},
+    {"2 ^ 64 == 18446744073709551616\n", "true"},
+    {"2 ^ 64 != 18446744073709551616\n", "false"},
+    {"2 ^ 64 == 2 ^ 64 + 1\n", "false"},
+    {"2 ^ 64 > 9223372036854775807\n", "true"},
+    {"-(2 ^ 64) < -9223372036854775808\n", "true"},
+    {"9223372036854775808 - 1 in [9223372036854775807]\n", "true"},
+    {"{9223372036854775808 - 1: 'a'}[9223372036854775807]\n", "a"},
+    {"{2 ^ 64: 'a'}[18446744073709551616]\n", "a"},
+  }
+
+  for _, test := range tests {
+    if got := run(t, test.source); got != test.want {
+      t.Errorf("%s\ngot %s, want %s", test.source, got, test.want)
+    }
+  }
+}
+
+func TestNumberLiterals(t *testing.T) {
+  tests := []struct {
+    source string
+    want string
+  }{
+    {"0xff\n", "255"},
+    {"0XFF\n", "255"},
+    {"0o17\n", "15"},
+    {"0b1010\n", "10"},
+    {"1_000_000\n", "1000000"},
+    {"0b1_0\n", "2"},
+    {"0xffff_ffff_ffff_ffff_ff\n", "4722366482869645213695"},
+    {"99999999999999999999\n", "99999999999999999999"},
+    {"1_000.5\n", "1000.5"},
+    {"1e3\n", "1000.0"},
+    {"2.5e-1\n", "0.25"},
+    {"0xff == 255\n", "true"},
+  }
+
+  for _, test := range tests {
+    if got := run(t, test.source); got != test.want {
+      t.Errorf("%s\ngot %s, want %s", test.source, got, test.want)
+    }
+  }
+}
+
+func TestArithmeticErrors(t *testing.T) {
+  tests := []struct {
+    source string
+    kind ErrorKind
+  }{
+    {"1 / 0\n", ZeroDivisionError},
+    {"1.0 / 0\n", ZeroDivisionError},
+    {"1 // 0.0\n", ZeroDivisionError},
+    {"1.5 % 0\n", ZeroDivisionError},
+    {"0 ^ -1\n", ZeroDivisionError},
+    {"0.0 ^ -2\n", ZeroDivisionError},
+    {"0 ^ -1.5\n", ZeroDivisionError},
+    {"9223372036854775807 ^ 9223372036854775807\n", OverflowError},
+    {"2 ^ (2 ^ 70)\n", OverflowError},
+    {"3 ^ 20000000\n", OverflowError},
+    {"[1, 2, 3].slice(-2, 'a')\n", TypeError},
+    {"[1, 2, 3].slice(nil)\n", TypeError},
+  }
+
+  for _, test := range tests {
+    if e := fails(t, test.source); e.Kind != test.kind {
+      t.Errorf("%s\ngot %s", test.source, e)
+    }
+  }
+}
+
+// Forking a block while it runs in the background mustn't touch the state the
+// run is using. This one needs -race to catch anything.
+func TestForkWhileRunning(t *testing.T) {
+  runtime := New()
+  source := "Gen ->\n" +
+    "  forever:\n" +
+    "    for x in [1, 2, 3]:\n" +
+    "      for c in 'ab':\n" +
+    "        return x\n"
+
+  if _, err := runtime.Interperet("<test>", source); err != nil {
+    t.Fatal(err)
+  }
+
+  v, _ := runtime.globals.Get("Gen")
+  gen := v.val.(*Block)
+
+  done := make(chan error)
+  go func() {
+    background := runtime.spawn()
+    for i := 0; i < 20000; i++ {
+      if _, err := gen.Call(background, nil); err != nil {
+        done <- err
+        return
+      }
+    }
+
+    done <- nil
+  }()
+
+  for {
+    select {
+    case err := <-done:
+      if err != nil {
+        t.Fatal(err)
+      }
+      return
+    default:
+      gen.Fork()
+    }
+  }
+}
+
+func TestContainersHoldingThemselves(t *testing.T) {
+  tests := []struct {
+    source string
+    want string
+  }{
+    {"x = [1]\nx.append(x)\nx\n", "[1, [...]]"},
+    {"m = {}\nm[1] = m\nm\n", "{1: {...}}"},
+    {"x = [1]\nm = {'x': x}\nx.append(m)\nx\n", "[1, {\"x\": [...]}]"},
+    {"x = [1]\ny = [x, x]\ny\n", "[[1], [1]]"},
+    {"x = [1]\nx.append(x)\ny = ...x\n[y[0], y[1][1][0]]\n", "[1, 1]"},
+  }
+
+  for _, test := range tests {
+    if got := run(t, test.source); got != test.want {
+      t.Errorf("%s\ngot %s, want %s", test.source, got, test.want)
+    }
+  }
+}
+
+func TestRecursionLimit(t *testing.T) {
+  tests := []struct {
+    source string
+    want string
+  }{
+    {
+      "F (n) ->\n" +
+      "  return F(n + 1)\n" +
+      "try:\n" +
+      "  F(0)\n" +
+      "rescue e:\n" +
+      "  e.kind\n",
+      "RecursionError",
+    },
+    {
+      // the depth comes back down once the calls return
+      "F (n) ->\n" +
+      "  if n > 0:\n" +
+      "    return F(n - 1) + 1\n" +
+      "  else:\n" +
+      "    return 0\n" +
+      "F(900) + F(900)\n",
+      "1800",
+    },
+  }
+
+  for _, test := range tests {
+    if got := run(t, test.source); got != test.want {
+      t.Errorf("%s\ngot %s, want %s", test.source, got, test.want)
+    }
+  }
+}
+
+func TestSetOutOfRange(t *testing.T) {
+  tests := []string{
+    "xs = [1, 2, 3]\nxs[5] = 3\n",
+    "xs = [1, 2, 3]\nxs[-4] = 3\n",
+    "xs = []\nxs[0] = 3\n",
+    "xs = [1]\nxs[2 ^ 70] = 3\n",
+  }
+
+  for _, source := range tests {
+    if e := fails(t, source); e.Kind != IndexError {
+      t.Errorf("%s\ngot %s", source, e)
+    }
+  }
+
+  if got, want := run(t, "xs = [1, 2, 3]\nxs[-1] = 4\nxs\n"), "[1, 2, 4]"; got != want {
+    t.Errorf("got %s, want %s", got, want)
+  }
+}
+
+// Iterating over a block that's running goes through a fresh instance, the
+// same as calling it does.
+func TestIterateRunningBlock(t *testing.T) {
+  source := "G (outer) ->\n" +
+    "  if outer:\n" +
+    "    return [x for x in G]\n" +
+    "  return 1\n" +
+    "  return 2\n" +
+    "G(true)\n"
+
+  if got, want := run(t, source), "[1, 2]"; got != want {
+    t.Errorf("got %s, want %s", got, want)
+  }
+}
+
+// Blocks are generators: each call carries on from the last return, and one
+// that falls off the end starts over.
+func TestGenerators(t *testing.T) {
+  tests := []struct {
+    source string
+    want string
+  }{
+    {
+      // the Counter example from the README
+      "Counter ->\n" +
+      "  i = 0\n" +
+      "  forever:\n" +
+      "    return i++\n" +
+      "a = new Counter\n" +
+      "b = new Counter\n" +
+      "i = a()\n" +
+      "j = b()\n" +
+      "c = new b\n" +
+      "k = c()\n" +
+      "[i, j, k, a(), b(), c(), b()]\n",
+      "[0, 0, 1, 1, 1, 2, 2]",
+    },
+    {
+      "G ->\n" +
+      "  x = 1\n" +
+      "  return x\n" +
+      "  x += 10\n" +
+      "  return x\n" +
+      "[G(), G(), G()]\n",
+      "[1, 11, 1]",
+    },
+    {
+      "G ->\n" +
+      "  for x in [1, 2, 3]:\n" +
+      "    return x * 10\n" +
+      "[G(), G(), G(), G()]\n",
+      "[10, 20, 30, 10]",
+    },
+    {
+      "G ->\n" +
+      "  if true:\n" +
+      "    return 1\n" +
+      "    return 2\n" +
+      "  return 3\n" +
+      "[G(), G(), G(), G()]\n",
+      "[1, 2, 3, 1]",
+    },
+    {
+      "G ->\n" +
+      "  i = 0\n" +
+      "  forever:\n" +
+      "    i += 1\n" +
+      "    if i % 2 == 0:\n" +
+      "      return i\n" +
+      "[G(), G(), G()]\n",
+      "[2, 4, 6]",
+    },
+    {
+      "G ->\n" +
+      "  for x in [1, 2]:\n" +
+      "    for y in 'ab':\n" +
+      "      return [x, y]\n" +
+      "[G(), G(), G(), G(), G()]\n",
+      `[[1, "a"], [1, "b"], [2, "a"], [2, "b"], [1, "a"]]`,
+    },
+    {
+      "G ->\n" +
+      "  return 1\n" +
+      "  return 2\n" +
+      "  return 3\n" +
+      "[[x for x in G], [x for x in G]]\n",
+      "[[1, 2, 3], [1, 2, 3]]",
+    },
+    {
+      // iterating carries on from wherever the block was left
+      "G ->\n" +
+      "  return 1\n" +
+      "  return 2\n" +
+      "  return 3\n" +
+      "G()\n" +
+      "[x for x in G]\n",
+      "[2, 3]",
+    },
+  }
+
+  for _, test := range tests {
+    if got := run(t, test.source); got != test.want {
+      t.Errorf("%s\ngot %s, want %s", test.source, got, test.want)
+    }
+  }
+}
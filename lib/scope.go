@@ -0,0 +1,81 @@
+package goon
+
+import "sync"
+
+// A Scope is one frame in the environment chain. Every block invocation gets
+// its own scope whose parent is the scope the block was defined in, so blocks
+// close over their surroundings.
+//
+// Background calls share scopes with whoever spawned them, so every access
+// goes through the lock.
+type Scope struct {
+  vars map[string]*Value
+  parent *Scope
+  lock sync.RWMutex
+}
+
+func NewScope(parent *Scope) *Scope {
+  return &Scope{vars: make(map[string]*Value), parent: parent}
+}
+
+func (s *Scope) Get(name string) (*Value, bool) {
+  for scope := s; scope != nil; scope = scope.parent {
+    if v, present := scope.Local(name); present {
+      return v, true
+    }
+  }
+
+  return nil, false
+}
+
+// Local looks up name in this scope only.
+func (s *Scope) Local(name string) (*Value, bool) {
+  s.lock.RLock()
+  defer s.lock.RUnlock()
+
+  v, present := s.vars[name]
+  return v, present
+}
+
+// Define binds name in this scope, shadowing anything further up the chain.
+func (s *Scope) Define(name string, v *Value) {
+  s.lock.Lock()
+  defer s.lock.Unlock()
+
+  s.vars[name] = v
+}
+
+// Set rebinds name in the nearest enclosing block that already has it, or
+// defines it here. Globals are never rebound from inside a block - assigning
+// to a global's name there creates a local instead.
+func (s *Scope) Set(name string, v *Value) {
+  for scope := s; scope != nil && scope.parent != nil; scope = scope.parent {
+    if scope.replace(name, v) {
+      return
+    }
+  }
+
+  s.Define(name, v)
+}
+
+func (s *Scope) replace(name string, v *Value) bool {
+  s.lock.Lock()
+  defer s.lock.Unlock()
+
+  if _, present := s.vars[name]; !present {
+    return false
+  }
+
+  s.vars[name] = v
+  return true
+}
+
+// Each calls fn with every binding in this scope.
+func (s *Scope) Each(fn func(name string, v *Value)) {
+  s.lock.RLock()
+  defer s.lock.RUnlock()
+
+  for name, v := range s.vars {
+    fn(name, v)
+  }
+}
@@ -2,7 +2,10 @@ package goon
 
 import (
   "fmt"
+  "math"
+  "math/big"
   "strconv"
+  "strings"
 )
 
 type ValueType int
@@ -11,14 +14,46 @@ const (
 
   _  = iota
   IntType
+  FloatType
   BoolType
+  StringType
+  BlockType
+  BuiltinType
+  PromiseType
+  ListType
+  MapType
+  ErrorType
 )
 
+var type_names = map[ValueType]string{
+  NilType:     "nil",
+  IntType:     "int",
+  FloatType:   "float",
+  BoolType:    "bool",
+  StringType:  "string",
+  BlockType:   "block",
+  BuiltinType: "builtin",
+  PromiseType: "promise",
+  ListType:    "list",
+  MapType:     "map",
+  ErrorType:   "error",
+}
+
+func (t ValueType) String() string {
+  return type_names[t]
+}
+
 type Value struct {
   val interface{}
   val_type ValueType
 }
 
+// Builtins are functions implemented in Go, like the methods on lists.
+type Builtin struct {
+  ident string
+  fn func(runtime *Runtime, args []*Value) (*Value, error)
+}
+
 var NIL = &Value{nil, NilType}
 var TRUE = &Value{true, BoolType}
 var FALSE = &Value{false, BoolType}
@@ -28,18 +63,128 @@ func (v *Value) String() string {
   case NilType:
     return "nil"
   case IntType:
-    return strconv.Itoa(v.val.(int));
+    if i, ok := v.Int(); ok {
+      return strconv.Itoa(i)
+    }
+
+    return v.val.(*big.Int).String()
+  case FloatType:
+    // always show a float as one, even when it's a whole number
+    s := strconv.FormatFloat(v.val.(float64), 'g', -1, 64)
+    if !strings.ContainsAny(s, ".eIN") {
+      s += ".0"
+    }
+
+    return s
   case BoolType:
     if (v.val.(bool)) {
       return "true"
     } else {
       return "false"
     }
+  case StringType:
+    return v.val.(string)
+  case BlockType:
+    return fmt.Sprintf("<block %s>", v.val.(*Block).name())
+  case BuiltinType:
+    return fmt.Sprintf("<builtin %s>", v.val.(*Builtin).ident)
+  case PromiseType:
+    return "<promise>"
+  case ListType:
+    return v.val.(*List).String()
+  case MapType:
+    return v.val.(*Map).String()
+  case ErrorType:
+    e := v.val.(*RuntimeError)
+    return fmt.Sprintf("%s: %s", e.Kind, e.Message)
   }
 
   return fmt.Sprintf("Unknown %d: %s", v.val_type, v.val);
 }
 
+// Repr is like String, but quotes strings. It's used for values inside
+// containers, where a bare string would be ambiguous.
+func (v *Value) Repr() string {
+  if v.val_type == StringType {
+    return strconv.Quote(v.val.(string))
+  }
+
+  return v.String()
+}
+
+// A printer writes out lists and maps, keeping track of the ones it's inside,
+// so one that holds itself comes out as `[...]` or `{...}` rather than going
+// round forever.
+type printer struct {
+  lists map[*List]bool
+  maps map[*Map]bool
+}
+
+func newPrinter() *printer {
+  return &printer{make(map[*List]bool), make(map[*Map]bool)}
+}
+
+func (p *printer) value(v *Value) string {
+  switch v.val_type {
+  case ListType:
+    return p.list(v.val.(*List))
+  case MapType:
+    return p.dict(v.val.(*Map))
+  }
+
+  return v.Repr()
+}
+
+func (p *printer) list(l *List) string {
+  if p.lists[l] {
+    return "[...]"
+  }
+  p.lists[l] = true
+  defer delete(p.lists, l)
+
+  l.lock.RLock()
+  items := append([]*Value(nil), l.items...)
+  l.lock.RUnlock()
+
+  parts := make([]string, len(items))
+  for i, item := range items {
+    parts[i] = p.value(item)
+  }
+
+  return "[" + strings.Join(parts, ", ") + "]"
+}
+
+func (p *printer) dict(m *Map) string {
+  if p.maps[m] {
+    return "{...}"
+  }
+  p.maps[m] = true
+  defer delete(p.maps, m)
+
+  parts := make([]string, 0, m.Len())
+  for _, k := range m.Keys() {
+    v, _ := m.Get(k)
+    parts = append(parts, p.value(k) + ": " + p.value(v))
+  }
+
+  return "{" + strings.Join(parts, ", ") + "}"
+}
+
+func (v *Value) Callable() bool {
+  return v.val_type == BlockType || v.val_type == BuiltinType
+}
+
+func (v *Value) Call(runtime *Runtime, args []*Value) (*Value, error) {
+  switch v.val_type {
+  case BlockType:
+    return v.val.(*Block).Call(runtime, args)
+  case BuiltinType:
+    return v.val.(*Builtin).fn(runtime, args)
+  }
+
+  return nil, NewError(TypeError, "Can't call %s", v.val_type)
+}
+
 func (v *Value) IsTruthy() bool {
   if (v.val_type == NilType) {
     return false
@@ -52,25 +197,87 @@ func (v *Value) IsTruthy() bool {
   return true
 }
 
-func (v *Value) Or(other *Value) *Value {
-  if v.IsTruthy() {
-    return v
-  } else if (other.IsTruthy()) {
-    return other
+// floats returns both values as floats, if they're both numbers and at least
+// one of them is a float. Mixing ints and floats promotes the ints.
+func floats(v *Value, other *Value) (float64, float64, bool) {
+  a, a_ok := v.float()
+  b, b_ok := other.float()
+
+  if !a_ok || !b_ok || (v.val_type != FloatType && other.val_type != FloatType) {
+    return 0, 0, false
   }
 
-  return FALSE
+  return a, b, true
+}
+
+func (v *Value) float() (float64, bool) {
+  switch v.val_type {
+  case IntType:
+    if i, ok := v.Int(); ok {
+      return float64(i), true
+    }
+
+    f, _ := new(big.Float).SetInt(v.val.(*big.Int)).Float64()
+    return f, true
+  case FloatType:
+    return v.val.(float64), true
+  }
+
+  return 0, false
 }
 
-func (v *Value) And(other *Value) *Value {
-  if (v.IsTruthy() && other.IsTruthy()){
-    return other
+// compareMixed compares an int with a float exactly, rather than rounding the
+// int to a float first, which would make 2 ^ 53 + 1 equal to 2 ^ 53. It
+// reports false unless it's one of each, or if the float is NaN.
+func compareMixed(v *Value, other *Value) (int, bool) {
+  if v.val_type == other.val_type {
+    return 0, false
   }
 
-  return FALSE
+  a, a_ok := v.exact()
+  b, b_ok := other.exact()
+  if !a_ok || !b_ok {
+    return 0, false
+  }
+
+  return a.Cmp(b), true
+}
+
+// exact returns a number as a big.Float that holds it exactly.
+func (v *Value) exact() (*big.Float, bool) {
+  switch v.val_type {
+  case IntType:
+    return new(big.Float).SetInt(v.bigInt()), true
+  case FloatType:
+    if f := v.val.(float64); !math.IsNaN(f) {
+      return big.NewFloat(f), true
+    }
+  }
+
+  return nil, false
 }
 
 func (v *Value) Equals(other *Value) *Value {
+  if v.val_type == IntType && other.val_type == IntType {
+    if compareInts(v, other) == 0 {
+      return TRUE
+    }
+
+    return FALSE
+  }
+
+  if c, ok := compareMixed(v, other); ok {
+    return boolValue(c == 0)
+  }
+
+  if a, b, ok := floats(v, other); ok {
+    if a == b {
+      return TRUE
+    }
+
+    return FALSE
+  }
+
   if (v.val_type == other.val_type && v.val == other.val) {
     return TRUE
   }
@@ -79,41 +286,378 @@ func (v *Value) Equals(other *Value) *Value {
 }
 
 func (v *Value) NotEquals(other *Value) *Value {
-  if (v.val_type == other.val_type && v.val == other.val) {
+  if v.Equals(other) == TRUE {
     return FALSE
   }
 
   return TRUE
 }
 
-func (v *Value) Add(other *Value) *Value {
+// compare orders numbers and strings. Anything else is a TypeError.
+func (v *Value) compare(other *Value) (int, error) {
+  if v.val_type == IntType && other.val_type == IntType {
+    return compareInts(v, other), nil
+  }
+
+  if c, ok := compareMixed(v, other); ok {
+    return c, nil
+  }
+
+  if a, b, ok := floats(v, other); ok {
+    if a < b {
+      return -1, nil
+    } else if a > b {
+      return 1, nil
+    }
+
+    return 0, nil
+  }
+
+  if v.val_type == StringType && other.val_type == StringType {
+    return strings.Compare(v.val.(string), other.val.(string)), nil
+  }
+
+  return 0, NewError(TypeError, "Can't compare %s with %s", v.val_type, other.val_type)
+}
+
+func boolValue(b bool) *Value {
+  if b {
+    return TRUE
+  }
+
+  return FALSE
+}
+
+func (v *Value) Less(other *Value) (*Value, error) {
+  c, err := v.compare(other)
+  if err != nil {
+    return nil, err
+  }
+
+  return boolValue(c < 0), nil
+}
+
+func (v *Value) LessOrEqual(other *Value) (*Value, error) {
+  c, err := v.compare(other)
+  if err != nil {
+    return nil, err
+  }
+
+  return boolValue(c <= 0), nil
+}
+
+func (v *Value) Greater(other *Value) (*Value, error) {
+  c, err := v.compare(other)
+  if err != nil {
+    return nil, err
+  }
+
+  return boolValue(c > 0), nil
+}
+
+func (v *Value) GreaterOrEqual(other *Value) (*Value, error) {
+  c, err := v.compare(other)
+  if err != nil {
+    return nil, err
+  }
+
+  return boolValue(c >= 0), nil
+}
+
+func (v *Value) Not() *Value {
+  return boolValue(!v.IsTruthy())
+}
+
+func (v *Value) Negate() (*Value, error) {
+  switch v.val_type {
+  case IntType:
+    return (&Value{0, IntType}).Subtract(v)
+  case FloatType:
+    return &Value{-v.val.(float64), FloatType}, nil
+  }
+
+  return nil, NewError(TypeError, "Can't negate %s", v.val_type)
+}
+
+// Contains tests for a key in a map, an item in a list or a substring in a
+// string.
+func (v *Value) Contains(other *Value) *Value {
+  switch v.val_type {
+  case MapType:
+    if _, present := v.val.(*Map).Get(other); present {
+      return TRUE
+    }
+  case ListType:
+    list := v.val.(*List)
+    for i := 0; i < list.Len(); i++ {
+      if list.Get(i).Equals(other) == TRUE {
+        return TRUE
+      }
+    }
+  case StringType:
+    if other.val_type == StringType && strings.Contains(v.val.(string), other.val.(string)) {
+      return TRUE
+    }
+  }
+
+  return FALSE
+}
+
+// mismatch is the error for an operator that can't be used on the two values.
+func mismatch(op string, v *Value, other *Value) error {
+  return NewError(TypeError, "Can't use %s on %s and %s", op, v.val_type, other.val_type)
+}
+
+// Floats raise on division by zero too, rather than making Inf or NaN, so
+// 1 / 0 and 1.0 / 0 fail the same way.
+func divisionByZero() error {
+  return NewError(ZeroDivisionError, "Division by zero")
+}
+
+func (v *Value) Add(other *Value) (*Value, error) {
+  if v.val_type == IntType && other.val_type == IntType {
+    if a, b, ok := smallInts(v, other); ok {
+      if c := a + b; (c > a) == (b > 0) {
+        return &Value{c, IntType}, nil
+      }
+    }
+
+    return newInt(new(big.Int).Add(v.bigInt(), other.bigInt())), nil
+  }
+
+  if a, b, ok := floats(v, other); ok {
+    return &Value{a + b, FloatType}, nil
+  }
+
+  if v.val_type == StringType && other.val_type == StringType {
+    return &Value{v.val.(string) + other.val.(string), StringType}, nil
+  }
+
+  return nil, mismatch("+", v, other)
+}
+
+func (v *Value) Subtract(other *Value) (*Value, error) {
   if v.val_type == IntType && other.val_type == IntType {
-    return &Value{v.val.(int) + other.val.(int), IntType};
+    if a, b, ok := smallInts(v, other); ok {
+      if c := a - b; (c < a) == (b > 0) {
+        return &Value{c, IntType}, nil
+      }
+    }
+
+    return newInt(new(big.Int).Sub(v.bigInt(), other.bigInt())), nil
   }
 
-  return nil
+  if a, b, ok := floats(v, other); ok {
+    return &Value{a - b, FloatType}, nil
+  }
+
+  return nil, mismatch("-", v, other)
 }
 
-func (v *Value) Subtract(other *Value) *Value {
+func (v *Value) Multiply(other *Value) (*Value, error) {
   if v.val_type == IntType && other.val_type == IntType {
-    return &Value{v.val.(int) - other.val.(int), IntType};
+    if a, b, ok := smallInts(v, other); ok {
+      c := a * b
+      if a == 0 || (c / a == b && !(a == -1 && b == math.MinInt)) {
+        return &Value{c, IntType}, nil
+      }
+    }
+
+    return newInt(new(big.Int).Mul(v.bigInt(), other.bigInt())), nil
   }
 
-  return nil
+  if a, b, ok := floats(v, other); ok {
+    return &Value{a * b, FloatType}, nil
+  }
+
+  return nil, mismatch("*", v, other)
 }
 
-func (v *Value) Multiply(other *Value) *Value {
+func (v *Value) Divide(other *Value) (*Value, error) {
   if v.val_type == IntType && other.val_type == IntType {
-    return &Value{v.val.(int) * other.val.(int), IntType};
+    if b, ok := other.Int(); ok && b == 0 {
+      return nil, divisionByZero()
+    }
+
+    if a, b, ok := smallInts(v, other); ok {
+      if !(a == math.MinInt && b == -1) {
+        return &Value{a / b, IntType}, nil
+      }
+    }
+
+    return newInt(new(big.Int).Quo(v.bigInt(), other.bigInt())), nil
+  }
+
+  if a, b, ok := floats(v, other); ok {
+    if b == 0 {
+      return nil, divisionByZero()
+    }
+
+    return &Value{a / b, FloatType}, nil
   }
 
-  return nil
+  return nil, mismatch("/", v, other)
 }
 
-func (v *Value) Divide(other *Value) *Value {
+// IntDivide divides and rounds down, unlike Divide which rounds towards zero.
+func (v *Value) IntDivide(other *Value) (*Value, error) {
   if v.val_type == IntType && other.val_type == IntType {
-    return &Value{v.val.(int) / other.val.(int), IntType};
+    if b, ok := other.Int(); ok && b == 0 {
+      return nil, divisionByZero()
+    }
+
+    if a, b, ok := smallInts(v, other); ok {
+      if !(a == math.MinInt && b == -1) {
+        q := a / b
+        if a % b != 0 && (a < 0) != (b < 0) {
+          q--
+        }
+
+        return &Value{q, IntType}, nil
+      }
+    }
+
+    q, _ := floorDivMod(v.bigInt(), other.bigInt())
+    return newInt(q), nil
+  }
+
+  if a, b, ok := floats(v, other); ok {
+    if b == 0 {
+      return nil, divisionByZero()
+    }
+
+    return &Value{math.Floor(a / b), FloatType}, nil
+  }
+
+  return nil, mismatch("//", v, other)
+}
+
+// Modulo takes the sign of the divisor, so it always agrees with IntDivide.
+func (v *Value) Modulo(other *Value) (*Value, error) {
+  if v.val_type == IntType && other.val_type == IntType {
+    if b, ok := other.Int(); ok && b == 0 {
+      return nil, divisionByZero()
+    }
+
+    if a, b, ok := smallInts(v, other); ok {
+      m := a % b
+      if m != 0 && (m < 0) != (b < 0) {
+        m += b
+      }
+
+      return &Value{m, IntType}, nil
+    }
+
+    _, m := floorDivMod(v.bigInt(), other.bigInt())
+    return newInt(m), nil
+  }
+
+  if a, b, ok := floats(v, other); ok {
+    if b == 0 {
+      return nil, divisionByZero()
+    }
+
+    m := math.Mod(a, b)
+    if m != 0 && (m < 0) != (b < 0) {
+      m += b
+    }
+
+    return &Value{m, FloatType}, nil
+  }
+
+  return nil, mismatch("%", v, other)
+}
+
+// maxPowerBits is the most bits an int raised to an int can come to. Past
+// that, working it out would run out of memory long before it finished.
+const maxPowerBits = 1 << 24
+
+// Power raises ints to ints exactly, as long as the exponent isn't negative.
+// Anything else is done with floats. Zero to a negative power is a division
+// by zero, the same as 1 / 0.
+func (v *Value) Power(other *Value) (*Value, error) {
+  a, a_ok := v.float()
+  b, b_ok := other.float()
+  if a_ok && b_ok && a == 0 && b < 0 {
+    return nil, divisionByZero()
+  }
+
+  if v.val_type == IntType && other.val_type == IntType {
+    if other.bigInt().Sign() >= 0 {
+      base, exponent := v.bigInt(), other.bigInt()
+
+      // 0, 1 and -1 stay small whatever the exponent
+      if bits := int64(base.BitLen()); bits > 1 {
+        if !exponent.IsInt64() || exponent.Int64() > maxPowerBits / bits {
+          return nil, NewError(OverflowError, "Result of ^ is too big to hold")
+        }
+      }
+
+      return newInt(new(big.Int).Exp(base, exponent, nil)), nil
+    }
+
+    return &Value{math.Pow(a, b), FloatType}, nil
+  }
+
+  if a, b, ok := floats(v, other); ok {
+    return &Value{math.Pow(a, b), FloatType}, nil
+  }
+
+  return nil, mismatch("^", v, other)
+}
+
+func floorDivMod(a *big.Int, b *big.Int) (*big.Int, *big.Int) {
+  q, m := new(big.Int).QuoRem(a, b, new(big.Int))
+  if m.Sign() != 0 && m.Sign() != b.Sign() {
+    q.Sub(q, big.NewInt(1))
+    m.Add(m, b)
+  }
+
+  return q, m
+}
+
+// Ints are held as Go ints, and only switch over to big.Ints when they don't
+// fit. newInt makes sure results that do fit go back to being small, so
+// there's only ever one way to hold a given number.
+func newInt(b *big.Int) *Value {
+  if b.IsInt64() && b.Int64() >= math.MinInt && b.Int64() <= math.MaxInt {
+    return &Value{int(b.Int64()), IntType}
+  }
+
+  return &Value{b, IntType}
+}
+
+// Int returns the value as a Go int, if it's an int small enough to be one.
+func (v *Value) Int() (int, bool) {
+  i, ok := v.val.(int)
+  return i, ok && v.val_type == IntType
+}
+
+func (v *Value) bigInt() *big.Int {
+  if i, ok := v.Int(); ok {
+    return big.NewInt(int64(i))
+  }
+
+  return v.val.(*big.Int)
+}
+
+func smallInts(v *Value, other *Value) (int, int, bool) {
+  a, a_ok := v.Int()
+  b, b_ok := other.Int()
+
+  return a, b, a_ok && b_ok
+}
+
+func compareInts(v *Value, other *Value) int {
+  if a, b, ok := smallInts(v, other); ok {
+    if a < b {
+      return -1
+    } else if a > b {
+      return 1
+    }
+
+    return 0
   }
 
-  return nil
+  return v.bigInt().Cmp(other.bigInt())
 }